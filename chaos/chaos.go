@@ -0,0 +1,236 @@
+// Package chaos decides when DNS servers change latency, stop or start.
+// All decisions of a run come from a single seeded source, so a run can be
+// reproduced from its seed, or recorded and replayed against another policy.
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Action is what an Event does to a DNS server.
+type Action string
+
+const (
+	SetLatency  Action = "latency"
+	Stop        Action = "stop"
+	Start       Action = "start"
+	InjectFault Action = "fault"
+	SetModel    Action = "model"
+)
+
+// Event is a single chaos decision, applied At a given offset from the start of the test.
+type Event struct {
+	At      Duration `json:"at"`
+	Server  string   `json:"server"`
+	Action  Action   `json:"action"`
+	Latency Duration `json:"latency,omitempty"`
+	// Fault, Rate and Delay describe the fault injected by a fault event, a Rate of 0 clears it.
+	Fault string   `json:"fault,omitempty"`
+	Rate  float64  `json:"rate,omitempty"`
+	Delay Duration `json:"delay,omitempty"`
+	// Model is the latency model a model event sets, see dnsserver.ParseLatencyModel.
+	Model string `json:"model,omitempty"`
+}
+
+// Duration is a time.Duration that reads and writes as a string like "1.5s",
+// so schedules can be read and edited by hand.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "duration must be a string")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Source yields chaos events in chronological order.
+type Source interface {
+	// Next returns the next event, ok is false once there are no more events.
+	Next() (e Event, ok bool)
+}
+
+// Config holds the bounds of the generated chaos.
+type Config struct {
+	// LatencyPeriod is how often a server gets a new latency.
+	LatencyPeriod time.Duration
+	// MinLatency and MaxLatency bound the ceiling of the random latencies.
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// MinStopStartPeriod and MaxStopStartPeriod bound how often a server is stopped or started.
+	MinStopStartPeriod time.Duration
+	MaxStopStartPeriod time.Duration
+}
+
+// Validate checks the bounds make sense: latencies are drawn in whole milliseconds, of at least 1ms,
+// and stop/start periods in whole seconds, of at least 1s.
+func (c Config) Validate() error {
+	if c.LatencyPeriod <= 0 {
+		return fmt.Errorf("latency period must be greater than 0: %s", c.LatencyPeriod)
+	}
+	if c.MinLatency < time.Millisecond {
+		return fmt.Errorf("min latency must be at least 1ms: %s", c.MinLatency)
+	}
+	if c.MaxLatency < c.MinLatency {
+		return fmt.Errorf("max latency %s is less than min latency %s", c.MaxLatency, c.MinLatency)
+	}
+	if c.MinStopStartPeriod < time.Second {
+		return fmt.Errorf("min stop/start period must be at least 1s: %s", c.MinStopStartPeriod)
+	}
+	if c.MaxStopStartPeriod < c.MinStopStartPeriod {
+		return fmt.Errorf("max stop/start period %s is less than min stop/start period %s", c.MaxStopStartPeriod, c.MinStopStartPeriod)
+	}
+	return nil
+}
+
+// Generator is a Source of random events. Two generators with the same seed,
+// servers and config yield the same events.
+type Generator struct {
+	r       *rand.Rand
+	servers []string
+	// running is what the generator knows of the state of the servers, see Observe.
+	running []bool
+	mux     sync.Mutex
+
+	latencyPeriod   time.Duration
+	maxLatencyMs    int
+	stopStartPeriod time.Duration
+
+	nextLatency   time.Duration
+	nextStopStart time.Duration
+}
+
+// NewGenerator creates a new Generator. All servers are assumed to be running at the start.
+func NewGenerator(seed int64, servers []string, cfg Config) (*Generator, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no servers to generate chaos for")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	r := rand.New(rand.NewSource(seed))
+	g := &Generator{
+		r:             r,
+		servers:       servers,
+		running:       make([]bool, len(servers)),
+		latencyPeriod: cfg.LatencyPeriod,
+	}
+	for i := range g.running {
+		g.running[i] = true
+	}
+	// the ceiling of the latencies and the stop/start period are drawn once per run.
+	minMs, maxMs := int(cfg.MinLatency/time.Millisecond), int(cfg.MaxLatency/time.Millisecond)
+	g.maxLatencyMs = r.Intn(maxMs-minMs+1) + minMs
+	minSecs, maxSecs := int(cfg.MinStopStartPeriod/time.Second), int(cfg.MaxStopStartPeriod/time.Second)
+	g.stopStartPeriod = time.Duration(r.Intn(maxSecs-minSecs+1)+minSecs) * time.Second
+
+	g.nextLatency = g.latencyPeriod
+	g.nextStopStart = g.stopStartPeriod
+	return g, nil
+}
+
+// Observe tells the generator about an event applied to a server from elsewhere, like a server
+// stopped from the keyboard, so it doesn't stop it again or give it a latency while it's stopped.
+func (g *Generator) Observe(e Event) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	for i, server := range g.servers {
+		if server == e.Server && (e.Action == Stop || e.Action == Start) {
+			g.running[i] = e.Action == Start
+		}
+	}
+}
+
+// Next implements Source, a Generator never runs out of events.
+func (g *Generator) Next() (Event, bool) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	for {
+		if g.nextStopStart <= g.nextLatency {
+			at := g.nextStopStart
+			g.nextStopStart += g.stopStartPeriod
+
+			// stop a random server, or start it again if it was stopped.
+			i := g.r.Intn(len(g.servers))
+			action := Stop
+			if !g.running[i] {
+				action = Start
+			}
+			g.running[i] = !g.running[i]
+			return Event{At: Duration(at), Server: g.servers[i], Action: action}, true
+		}
+
+		at := g.nextLatency
+		g.nextLatency += g.latencyPeriod
+
+		// assign a random latency to a random server, unless it is stopped.
+		i := g.r.Intn(len(g.servers))
+		if !g.running[i] {
+			continue
+		}
+		latency := time.Duration(g.r.Intn(g.maxLatencyMs)) * time.Millisecond
+		return Event{At: Duration(at), Server: g.servers[i], Action: SetLatency, Latency: Duration(latency)}, true
+	}
+}
+
+// Run applies the events of src at their time, counted from when Run is called,
+// until src runs out of events or ctx is done.
+func Run(ctx context.Context, src Source, apply func(Event)) {
+	start := time.Now()
+	for {
+		e, ok := src.Next()
+		if !ok {
+			return
+		}
+		timer := time.NewTimer(time.Until(start.Add(time.Duration(e.At))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			apply(e)
+		}
+	}
+}
+
+// Switch pauses and resumes the chaos of a run. Events that fall due while it's paused
+// are skipped, not held back, so resuming doesn't apply them all at once.
+type Switch struct {
+	paused atomic.Bool
+}
+
+// Pause stops applying events until Resume is called.
+func (s *Switch) Pause() {
+	s.paused.Store(true)
+}
+
+// Resume applies events again.
+func (s *Switch) Resume() {
+	s.paused.Store(false)
+}
+
+// Paused tells whether events are being skipped.
+func (s *Switch) Paused() bool {
+	return s.paused.Load()
+}
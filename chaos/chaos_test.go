@@ -0,0 +1,208 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	LatencyPeriod:      time.Second,
+	MinLatency:         10 * time.Millisecond,
+	MaxLatency:         500 * time.Millisecond,
+	MinStopStartPeriod: 5 * time.Second,
+	MaxStopStartPeriod: 20 * time.Second,
+}
+
+var testServers = []string{"server1", "server2", "server3"}
+
+// newGenerator returns a generator of the test servers with the test config.
+func newGenerator(t *testing.T, seed int64) *Generator {
+	t.Helper()
+	g, err := NewGenerator(seed, testServers, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// take returns the first n events of src.
+func take(src Source, n int) []Event {
+	events := make([]Event, 0, n)
+	for len(events) < n {
+		e, ok := src.Next()
+		if !ok {
+			break
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestGeneratorIsDeterministic(t *testing.T) {
+	first := take(newGenerator(t, 42), 200)
+	second := take(newGenerator(t, 42), 200)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected event %d to be %+v with the same seed, got %+v", i, first[i], second[i])
+		}
+	}
+
+	other := take(newGenerator(t, 43), 200)
+	same := true
+	for i := range first {
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Error("Expected another seed to yield other events")
+	}
+}
+
+func TestGeneratorEvents(t *testing.T) {
+	events := take(newGenerator(t, 7), 500)
+	running := map[string]bool{"server1": true, "server2": true, "server3": true}
+	var last Duration
+	for i, e := range events {
+		if e.At < last {
+			t.Fatalf("Expected events in chronological order, event %d at %s comes after %s", i, e.At, last)
+		}
+		last = e.At
+		switch e.Action {
+		case Stop, Start:
+			if running[e.Server] != (e.Action == Stop) {
+				t.Fatalf("Expected event %d to stop a running server or start a stopped one, got %+v", i, e)
+			}
+			running[e.Server] = e.Action == Start
+		case SetLatency:
+			if !running[e.Server] {
+				t.Fatalf("Expected no latency to be set on stopped %s, got %+v", e.Server, e)
+			}
+			if e.Latency < 0 || time.Duration(e.Latency) >= testConfig.MaxLatency {
+				t.Fatalf("Expected a latency below %s, got %s", testConfig.MaxLatency, e.Latency)
+			}
+		default:
+			t.Fatalf("Expected only stop, start and latency events, got %+v", e)
+		}
+	}
+}
+
+func TestNewGeneratorValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*Config)
+		wantErr string
+	}{
+		{"no latency period", func(c *Config) { c.LatencyPeriod = 0 }, "latency period"},
+		{"min latency under 1ms", func(c *Config) { c.MinLatency = 0 }, "min latency must be at least 1ms"},
+		{"max latency under 1ms", func(c *Config) { c.MinLatency, c.MaxLatency = 500*time.Microsecond, 900*time.Microsecond }, "min latency must be at least 1ms"},
+		{"max latency under min", func(c *Config) { c.MaxLatency = 5 * time.Millisecond }, "max latency 5ms is less than"},
+		{"stop/start period under 1s", func(c *Config) { c.MinStopStartPeriod = 0 }, "min stop/start period must be at least 1s"},
+		{"max stop/start period under min", func(c *Config) { c.MaxStopStartPeriod = 2 * time.Second }, "max stop/start period 2s is less than"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig
+			tc.change(&cfg)
+			if _, err := NewGenerator(42, testServers, cfg); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+	if _, err := NewGenerator(42, nil, testConfig); err == nil {
+		t.Error("Expected an error without servers")
+	}
+}
+
+func TestGeneratorObservesOtherEvents(t *testing.T) {
+	g := newGenerator(t, 7)
+	// every server is stopped from elsewhere, so all the generator can do is start them again.
+	for _, server := range testServers {
+		g.Observe(Event{Server: server, Action: Stop})
+	}
+	e, _ := g.Next()
+	if e.Action != Start {
+		t.Errorf("Expected the first event to start a stopped server, got %+v", e)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	recorder := NewRecorder(42)
+	events := take(newGenerator(t, 42), 50)
+	// events the generator doesn't make, so every field goes through JSON.
+	at := events[len(events)-1].At
+	events = append(events,
+		Event{At: at + Duration(time.Second), Server: "server2", Action: InjectFault, Fault: "delay", Rate: 0.25, Delay: Duration(300 * time.Millisecond)},
+		Event{At: at + Duration(2*time.Second), Server: "server3", Action: SetModel, Model: "lognormal:20ms:0.5"},
+	)
+	for _, e := range events {
+		recorder.Add(e)
+	}
+	path := filepath.Join(t.TempDir(), "schedule.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatalf("Expected no error saving the schedule, got %v", err)
+	}
+
+	schedule, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected no error loading the schedule, got %v", err)
+	}
+	if schedule.Seed != 42 {
+		t.Errorf("Expected seed 42, got %d", schedule.Seed)
+	}
+	replayed := take(schedule.Replay(), len(events)+1)
+	if len(replayed) != len(events) {
+		t.Fatalf("Expected %d events to be replayed, got %d", len(events), len(replayed))
+	}
+	for i := range events {
+		if replayed[i] != events[i] {
+			t.Errorf("Expected event %d to be replayed as %+v, got %+v", i, events[i], replayed[i])
+		}
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	b, err := json.Marshal(Event{At: Duration(1500 * time.Millisecond), Server: "server1", Action: Stop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"at":"1.5s","server":"server1","action":"stop"}`; string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+
+	var e Event
+	if err := json.Unmarshal([]byte(`{"at": 1500}`), &e); err == nil {
+		t.Error("Expected an error for a duration that isn't a string")
+	}
+	if err := json.Unmarshal([]byte(`{"at": "soon"}`), &e); err == nil {
+		t.Error("Expected an error for a duration that doesn't parse")
+	}
+}
+
+func TestRunAppliesEventsAtTheirTime(t *testing.T) {
+	schedule := &Schedule{Events: []Event{
+		{At: 0, Server: "server1", Action: Stop},
+		{At: Duration(20 * time.Millisecond), Server: "server1", Action: Start},
+	}}
+	start := time.Now()
+	var applied []time.Duration
+	Run(context.Background(), schedule.Replay(), func(Event) {
+		applied = append(applied, time.Since(start))
+	})
+	if len(applied) != 2 {
+		t.Fatalf("Expected 2 events to be applied, got %d", len(applied))
+	}
+	if applied[1] < 20*time.Millisecond {
+		t.Errorf("Expected the second event to be applied after 20ms, got %s", applied[1])
+	}
+
+	// once the context is done, nothing more is applied.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	schedule.Events[0].At = Duration(time.Hour)
+	Run(ctx, schedule.Replay(), func(e Event) {
+		t.Errorf("Expected no event to be applied after the context is done, got %+v", e)
+	})
+}
@@ -0,0 +1,93 @@
+package chaos
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule is a recorded timeline of chaos events.
+type Schedule struct {
+	// Seed is the seed the events were generated with, for reference.
+	Seed   int64   `json:"seed"`
+	Events []Event `json:"events"`
+}
+
+// Load reads a schedule from the given JSON file.
+func Load(path string) (*Schedule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `reading schedule file "%s"`, path)
+	}
+	s := new(Schedule)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, errors.Wrapf(err, `parsing schedule file "%s"`, path)
+	}
+	return s, nil
+}
+
+// Save writes the schedule to the given JSON file.
+func (s *Schedule) Save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding schedule")
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return errors.Wrapf(err, `writing schedule file "%s"`, path)
+	}
+	return nil
+}
+
+// Replay returns a Source that yields the events of the schedule.
+func (s *Schedule) Replay() Source {
+	return &replay{events: s.Events}
+}
+
+type replay struct {
+	events []Event
+}
+
+func (r *replay) Next() (Event, bool) {
+	if len(r.events) == 0 {
+		return Event{}, false
+	}
+	e := r.events[0]
+	r.events = r.events[1:]
+	return e, true
+}
+
+// Recorder records the events applied during a run.
+type Recorder struct {
+	mux      sync.Mutex
+	schedule Schedule
+}
+
+// NewRecorder creates a new Recorder for a run with the given seed.
+func NewRecorder(seed int64) *Recorder {
+	return &Recorder{schedule: Schedule{Seed: seed}}
+}
+
+// Add records an applied event.
+func (r *Recorder) Add(e Event) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.schedule.Events = append(r.schedule.Events, e)
+}
+
+// Save writes the events recorded so far to the given JSON file.
+func (r *Recorder) Save(path string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.schedule.Save(path)
+}
+
+// Events returns the events recorded so far.
+func (r *Recorder) Events() []Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	events := make([]Event, len(r.schedule.Events))
+	copy(events, r.schedule.Events)
+	return events
+}
@@ -0,0 +1,53 @@
+// Command corefiles generates the Corefiles in COREFILE_OUTPUT_DIR from the
+// templates in COREFILE_TEMPLATE_DIR, forwarding to the DNS servers in DNS_SERVERS,
+// and the certificate they answer DNS over TLS and HTTPS with, so CoreDNS can be
+// started before the tester.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/ewma-policy-poc/config"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+	"github.com/tiagomelo/ewma-policy-poc/parser"
+)
+
+func run() error {
+	cfg, err := config.Read()
+	if err != nil {
+		return errors.Wrap(err, "reading config")
+	}
+	_, portStr, err := net.SplitHostPort(cfg.CorednsHost)
+	if err != nil {
+		return errors.Wrapf(err, `parsing coredns host "%s"`, cfg.CorednsHost)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return errors.Wrapf(err, `parsing coredns port "%s"`, portStr)
+	}
+	if _, err := dnsserver.LoadOrCreateCertificate(cfg.DnsServerCertFile, cfg.DnsServerKeyFile); err != nil {
+		return errors.Wrap(err, "preparing certificate")
+	}
+	data := &parser.CorefileData{
+		Port:         port,
+		Upstreams:    cfg.DnsServers.Addrs(),
+		TLSUpstreams: cfg.DnsServers.TLSAddrs(),
+		CertFile:     cfg.DnsServerCertFile,
+	}
+	if err := parser.ParseCorefiles(data, cfg.CorefileTemplateDir, cfg.CorefileOutputDir); err != nil {
+		return errors.Wrap(err, "parsing corefile templates")
+	}
+	fmt.Printf("corefiles generated in %s for %d DNS servers\n", cfg.CorefileOutputDir, len(cfg.DnsServers))
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -2,54 +2,79 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"runtime"
+	"strconv"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/pterm/pterm"
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+	"github.com/tiagomelo/ewma-policy-poc/compare"
 	"github.com/tiagomelo/ewma-policy-poc/config"
+	"github.com/tiagomelo/ewma-policy-poc/control"
+	"github.com/tiagomelo/ewma-policy-poc/corefile"
 	"github.com/tiagomelo/ewma-policy-poc/digger"
 	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+	"github.com/tiagomelo/ewma-policy-poc/instance"
+	"github.com/tiagomelo/ewma-policy-poc/load"
 	"github.com/tiagomelo/ewma-policy-poc/parser"
+	"github.com/tiagomelo/ewma-policy-poc/report"
+	"github.com/tiagomelo/ewma-policy-poc/scenario"
 	"github.com/tiagomelo/ewma-policy-poc/screen"
 	"github.com/tiagomelo/ewma-policy-poc/screen/stats"
-	"github.com/tiagomelo/ewma-policy-poc/task"
 	"github.com/tiagomelo/ewma-policy-poc/task/worker"
 )
 
-// to control worker pool's channel close.
-var closeOnce sync.Once
-
 const logFileName = "logs/tester.txt"
 
-type Options struct {
-	NumberOfDigs      int `short:"n" long:"number-of-digs" description:"Number of digs to perform" default:"-1"`
-	TestTime          int `short:"t" long:"test-time" description:"Duration of test in seconds" default:"-1"`
-	RequestsPerSecond int `short:"r" long:"rps" description:"Requests per second" required:"true"`
-}
+const (
+	// corednsDir is where the CoreDNS sources are.
+	corednsDir = "coredns"
+	// corednsBinary is where CoreDNS is built to when comparing policies.
+	corednsBinary = "logs/coredns"
+	// corednsMetricsPort is the port of the prometheus plugin in the Corefiles.
+	corednsMetricsPort = 9153
+	// corednsLogFileName is where CoreDNS logs to when it runs inside the tester.
+	corednsLogFileName = "logs/coredns.txt"
+)
 
-type serverConfig struct {
-	name    string
-	port    int
-	latency int
-}
+// defaultPolicy is the policy of the forward plugin when the Corefile doesn't set one.
+const defaultPolicy = "random"
 
-func serverConfigs(cfg *config.Config) []serverConfig {
-	return []serverConfig{
-		{name: cfg.DnsServer1Name, port: cfg.DnsServer1Port, latency: 1},
-		{name: cfg.DnsServer2Name, port: cfg.DnsServer2Port, latency: 1},
-		{name: cfg.DnsServer3Name, port: cfg.DnsServer3Port, latency: 1},
-	}
+// reportInterval is how long each interval of the upstream share over time is in the report.
+const reportInterval = 10 * time.Second
+
+type Options struct {
+	NumberOfDigs      int       `short:"n" long:"number-of-digs" description:"Number of digs to perform" default:"-1"`
+	TestTime          int       `short:"t" long:"test-time" description:"Duration of test in seconds" default:"-1"`
+	RequestsPerSecond int       `short:"r" long:"rps" description:"Requests per second" required:"true"`
+	Profile           string    `long:"profile" description:"Load profile: constant, ramp, step, sine or burst" default:"constant"`
+	PeakRps           float64   `long:"peak-rps" description:"Rate a ramp goes to, highest rate of a sine and rate of the bursts"`
+	ProfilePeriod     int       `long:"profile-period" description:"Seconds a ramp takes, each step lasts, a sine period lasts and between bursts" default:"60"`
+	Steps             []float64 `long:"step" description:"Rate a step profile goes to after the previous one, repeat it for each step"`
+	BurstDuration     int       `long:"burst-duration" description:"Seconds a burst lasts" default:"5"`
+	MaxInFlight       int       `long:"max-in-flight" description:"Maximum number of requests waiting for an answer, more are dropped" default:"1000"`
+	Seed              *int64    `short:"s" long:"seed" description:"Seed of the chaos schedule, random if not set"`
+	RecordSchedule    string    `long:"record-schedule" description:"File to record the chaos schedule to, to replay it later"`
+	ReplaySchedule    string    `long:"replay-schedule" description:"File to replay a recorded chaos schedule from, instead of generating one"`
+	Scenario          string    `long:"scenario" description:"YAML or JSON scenario file describing the chaos, instead of generating it"`
+	Corefile          string    `long:"corefile" description:"Corefile to run CoreDNS with inside the tester, instead of the one already running at COREDNS_HOST"`
+	Compare           []string  `long:"compare" description:"Corefile of a CoreDNS target to compare, repeat it for each policy"`
+	Report            string    `long:"report" description:"Path to save the report of the run to, with .json and .html extensions" default:"logs/report"`
+	CorednsBinary     string    `long:"coredns-binary" description:"CoreDNS binary used to compare policies, built from coredns/ if not set"`
+	Transport         string    `long:"transport" description:"Transport the queries are sent to CoreDNS over: udp, tcp, tls or https" default:"udp"`
 }
 
 func metricsHandler() http.Handler {
@@ -62,42 +87,91 @@ func metricsServer(cfg *config.Config) {
 	log.Fatal(http.ListenAndServe(port, nil))
 }
 
-func randomServerLatency(logger *log.Logger, cfg *config.Config, servers []*dnsserver.Server) {
-	seed := time.Now().UnixNano()
-	r := rand.New(rand.NewSource(seed))
-	randomAmount := r.Intn(cfg.RslMaxValueInMs-cfg.RslMinValueInMs+1) + cfg.RslMinValueInMs
-
-	ticker := time.NewTicker(time.Duration(cfg.RslPeriodInSeconds) * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		// Randomly select a server.
-		index := rand.Intn(len(servers))
-		server := servers[index]
+// controlServer serves the control API under /control. It has no auth, so it listens
+// apart from the metrics, on loopback unless CONTROL_API_ADDR says otherwise.
+func controlServer(cfg *config.Config, api *control.API) {
+	mux := http.NewServeMux()
+	mux.Handle("/control/", http.StripPrefix("/control", api))
+	log.Fatal(http.ListenAndServe(cfg.ControlApiAddr, mux))
+}
 
-		if server.IsRunning() {
-			// Assign a random latency.
-			newLatency := time.Duration(r.Intn(randomAmount)) * time.Millisecond
-			server.SetLatency(newLatency)
-			logger.Printf("Set latency of server %s to %s\n", server.GetName(), newLatency)
-		}
+// controlURL returns the URL of the list of servers of the control API.
+func controlURL(cfg *config.Config) string {
+	host, port, err := net.SplitHostPort(cfg.ControlApiAddr)
+	if err != nil {
+		return cfg.ControlApiAddr
 	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s/control/servers", net.JoinHostPort(host, port))
 }
 
-func stopOrStartServer(logger *log.Logger, cfg *config.Config, stats *stats.Statistics, servers []*dnsserver.Server) {
+// chaosSource returns the source of the chaos events of this run, and the seed they come from.
+func chaosSource(cfg *config.Config, opts Options) (chaos.Source, int64, error) {
+	names := make([]string, len(cfg.DnsServers))
+	for i, upstream := range cfg.DnsServers {
+		names[i] = upstream.Name
+	}
+	if opts.Scenario != "" {
+		scenario, err := scenario.Load(opts.Scenario, names)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scenario.Source(), 0, nil
+	}
+	if opts.ReplaySchedule != "" {
+		schedule, err := chaos.Load(opts.ReplaySchedule)
+		if err != nil {
+			return nil, 0, err
+		}
+		return schedule.Replay(), schedule.Seed, nil
+	}
+	// 0 is a seed like any other, only a missing --seed means a random one.
 	seed := time.Now().UnixNano()
-	r := rand.New(rand.NewSource(seed))
-	randomAmount := r.Intn(cfg.SsMaxPeriodInSeconds-cfg.SsMinPeriodInSeconds+1) + cfg.SsMinPeriodInSeconds
-
-	ticker := time.NewTicker(time.Duration(randomAmount) * time.Second)
-	defer ticker.Stop()
+	if opts.Seed != nil {
+		seed = *opts.Seed
+	}
+	generator, err := chaos.NewGenerator(seed, names, chaos.Config{
+		LatencyPeriod:      time.Duration(cfg.RslPeriodInSeconds) * time.Second,
+		MinLatency:         time.Duration(cfg.RslMinValueInMs) * time.Millisecond,
+		MaxLatency:         time.Duration(cfg.RslMaxValueInMs) * time.Millisecond,
+		MinStopStartPeriod: time.Duration(cfg.SsMinPeriodInSeconds) * time.Second,
+		MaxStopStartPeriod: time.Duration(cfg.SsMaxPeriodInSeconds) * time.Second,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return generator, seed, nil
+}
 
-	for range ticker.C {
-		// Randomly select a server.
-		index := rand.Intn(len(servers))
-		server := servers[index]
+// loadProfile returns the load profile of this run, its random arrivals seeded with seed.
+func loadProfile(opts Options, seed int64) (load.Profile, error) {
+	return load.NewProfile(load.ProfileConfig{
+		Name:          opts.Profile,
+		Rate:          float64(opts.RequestsPerSecond),
+		PeakRate:      opts.PeakRps,
+		Period:        time.Duration(opts.ProfilePeriod) * time.Second,
+		Steps:         opts.Steps,
+		BurstDuration: time.Duration(opts.BurstDuration) * time.Second,
+		Seed:          seed,
+	})
+}
 
-		// stop or restart it.
+// applyChaosEvent changes the latency of a server, stops it or starts it again.
+func applyChaosEvent(logger *log.Logger, stats *stats.Statistics, servers map[string]*dnsserver.Server, e chaos.Event) {
+	server, ok := servers[e.Server]
+	if !ok {
+		logger.Printf("Ignoring chaos event for unknown server %s\n", e.Server)
+		return
+	}
+	switch e.Action {
+	case chaos.SetLatency:
+		// a stopped server keeps its new latency for when it starts again.
+		newLatency := time.Duration(e.Latency)
+		server.SetLatency(newLatency)
+		logger.Printf("Set median latency of server %s to %s\n", server.GetName(), newLatency)
+	case chaos.Stop:
 		if server.IsRunning() {
 			if err := server.Stop(); err != nil {
 				logger.Printf("Error when stopping server %s: %v\n", server.GetName(), err)
@@ -106,12 +180,31 @@ func stopOrStartServer(logger *log.Logger, cfg *config.Config, stats *stats.Stat
 				stats.IncrTotalUnavailableServers()
 				stats.DecrTotalAvailableServers()
 			}
-		} else {
+		}
+	case chaos.Start:
+		if !server.IsRunning() {
 			server.Run()
 			logger.Printf("Re-started server %s\n", server.GetName())
 			stats.IncrTotalAvailableServers()
 			stats.DecrTotalUnavailableServers()
 		}
+	case chaos.InjectFault:
+		fault := dnsserver.Fault{Mode: dnsserver.FaultMode(e.Fault), Rate: e.Rate, Delay: time.Duration(e.Delay)}
+		if err := server.SetFault(fault); err != nil {
+			logger.Printf("Error when injecting fault into server %s: %v\n", server.GetName(), err)
+		} else {
+			logger.Printf("Set %s fault of server %s to a rate of %v\n", e.Fault, server.GetName(), e.Rate)
+		}
+	case chaos.SetModel:
+		model, err := dnsserver.ParseLatencyModel(e.Model)
+		if err != nil {
+			logger.Printf("Error when setting the latency model of server %s: %v\n", server.GetName(), err)
+		} else {
+			server.SetLatencyModel(model)
+			logger.Printf("Set latency model of server %s to %s\n", server.GetName(), model)
+		}
+	default:
+		logger.Printf("Ignoring unknown chaos action %q for server %s\n", e.Action, e.Server)
 	}
 }
 
@@ -125,6 +218,106 @@ func parseTemplateFilesForMetrics(cfg *config.Config) error {
 	return nil
 }
 
+// parseCorefileTemplates generates the Corefiles forwarding to the configured DNS servers.
+func parseCorefileTemplates(cfg *config.Config) error {
+	_, port, err := corednsHostPort(cfg)
+	if err != nil {
+		return err
+	}
+	data := &parser.CorefileData{
+		Port:         port,
+		Upstreams:    cfg.DnsServers.Addrs(),
+		TLSUpstreams: cfg.DnsServers.TLSAddrs(),
+		CertFile:     cfg.DnsServerCertFile,
+	}
+	if err := parser.ParseCorefiles(data, cfg.CorefileTemplateDir, cfg.CorefileOutputDir); err != nil {
+		return errors.Wrap(err, "parsing corefile templates")
+	}
+	return nil
+}
+
+// clientTLSConfig returns the TLS config of queries sent over TLS or HTTPS, trusting
+// the certificate of the DNS servers, which CoreDNS can serve with too.
+func clientTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	certPEM, err := os.ReadFile(cfg.DnsServerCertFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, `reading certificate "%s"`, cfg.DnsServerCertFile)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(certPEM) {
+		return nil, errors.Errorf(`no certificate in "%s"`, cfg.DnsServerCertFile)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+// corednsHostPort returns the host and port of COREDNS_HOST.
+func corednsHostPort(cfg *config.Config) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(cfg.CorednsHost)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, `parsing coredns host "%s"`, cfg.CorednsHost)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, `parsing coredns port "%s"`, portStr)
+	}
+	return host, port, nil
+}
+
+// startCoredns starts CoreDNS inside the tester with the given Corefile, listening on
+// COREDNS_HOST and forwarding to the tester's own DNS servers.
+func startCoredns(cfg *config.Config, path string) (*instance.Instance, error) {
+	_, port, err := corednsHostPort(cfg)
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(corednsLogFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, `opening log file "%s"`, corednsLogFileName)
+	}
+	return instance.StartFile(path, func(conf string) string {
+		upstreams := cfg.DnsServers.Addrs()
+		if corefile.ForwardsOverTLS(conf) {
+			upstreams = cfg.DnsServers.TLSAddrs()
+		}
+		return corefile.SetUpstreams(corefile.SetPort(conf, port), upstreams)
+	}, log.New(logFile, "", log.LstdFlags))
+}
+
+// startCompareTargets starts a CoreDNS target for each of the Corefiles to compare,
+// building CoreDNS first unless a binary is given.
+func startCompareTargets(logger *log.Logger, cfg *config.Config, opts Options, tlsConfig *tls.Config) ([]*compare.Target, error) {
+	binary := opts.CorednsBinary
+	if binary == "" {
+		fmt.Println("building coredns:", corednsBinary)
+		if err := compare.Build(corednsDir, corednsBinary); err != nil {
+			return nil, err
+		}
+		binary = corednsBinary
+	}
+	host, port, err := corednsHostPort(cfg)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := compare.Start(logger, compare.Config{
+		Binary:          binary,
+		Corefiles:       opts.Compare,
+		Upstreams:       cfg.DnsServers.Addrs(),
+		TLSUpstreams:    cfg.DnsServers.TLSAddrs(),
+		Host:            host,
+		BasePort:        port,
+		MetricsBasePort: corednsMetricsPort,
+		Transport:       opts.Transport,
+		TLSConfig:       tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		fmt.Printf("coredns %s policy: %s\n", t.Name, t.Addr)
+	}
+	return targets, nil
+}
+
 func run(logger *log.Logger, opts Options) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -143,23 +336,90 @@ func run(logger *log.Logger, opts Options) error {
 		return err
 	}
 
-	serverConfigs := serverConfigs(cfg)
+	// certificate of the DNS servers over TLS and HTTPS, generated the first time.
+	cert, err := dnsserver.LoadOrCreateCertificate(cfg.DnsServerCertFile, cfg.DnsServerKeyFile)
+	if err != nil {
+		return errors.Wrap(err, "preparing certificate")
+	}
+	tlsConfig, err := clientTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// generating the Corefiles for the configured DNS servers.
+	if err := parseCorefileTemplates(cfg); err != nil {
+		return err
+	}
 
-	servers := make([]*dnsserver.Server, len(serverConfigs))
+	events, seed, err := chaosSource(cfg, opts)
+	if err != nil {
+		return errors.Wrap(err, "preparing chaos schedule")
+	}
+	logger.Printf("main: chaos seed %d\n", seed)
+
+	profile, err := loadProfile(opts, seed)
+	if err != nil {
+		return errors.Wrap(err, "preparing load profile")
+	}
+	// the rate is scaled from the keyboard.
+	adjustable := load.NewAdjustable(profile)
+
+	servers := make(map[string]*dnsserver.Server, len(cfg.DnsServers))
+	// the servers in the order they are configured, as shown on screen.
+	orderedServers := make([]*dnsserver.Server, 0, len(cfg.DnsServers))
 
 	fmt.Println("check execution logs:")
 	fmt.Println("tester:", logFileName)
 
-	for i, config := range serverConfigs {
-		var err error
-		servers[i], err = dnsserver.NewServer(config.name, config.port, config.latency)
+	for _, upstream := range cfg.DnsServers {
+		server, err := dnsserver.NewServer(upstream.Name, upstream.Port, int(upstream.Latency.Milliseconds()))
 		if err != nil {
-			return errors.Wrapf(err, `creating server "%s"`, config.name)
+			return errors.Wrapf(err, `creating server "%s"`, upstream.Name)
 		}
-		fmt.Printf("server %s: %s\n", servers[i].GetName(), servers[i].GetLogFileName())
-		servers[i].Run()
+		server.SetJitter(upstream.Jitter)
+		server.SetTLS(cert, upstream.TLSPort, upstream.HTTPSPort)
+		if spec, ok := cfg.DnsServerLatencyModels[upstream.Name]; ok {
+			model, err := dnsserver.ParseLatencyModel(spec)
+			if err != nil {
+				return errors.Wrapf(err, `parsing latency model of server "%s"`, upstream.Name)
+			}
+			server.SetLatencyModel(model)
+		}
+		fmt.Printf("server %s: %s\n", server.GetName(), server.GetLogFileName())
+		server.Run()
+		servers[upstream.Name] = server
+		orderedServers = append(orderedServers, server)
 	}
 
+	// running CoreDNS inside the tester.
+	if opts.Corefile != "" {
+		coredns, err := startCoredns(cfg, opts.Corefile)
+		if err != nil {
+			return errors.Wrap(err, "starting coredns")
+		}
+		defer coredns.Stop()
+		fmt.Println("coredns:", corednsLogFileName)
+	}
+
+	// comparing policies: every query goes to a CoreDNS target per policy.
+	var targets []*compare.Target
+	if len(opts.Compare) > 0 {
+		if targets, err = startCompareTargets(logger, cfg, opts, tlsConfig); err != nil {
+			return errors.Wrap(err, "starting coredns targets")
+		}
+		defer compare.Stop(targets)
+	}
+
+	switch {
+	case opts.Scenario != "":
+		fmt.Println("\nchaos scenario:", opts.Scenario)
+	case opts.ReplaySchedule != "":
+		fmt.Println("\nchaos schedule:", opts.ReplaySchedule)
+	default:
+		fmt.Println("\nchaos seed:", seed)
+	}
+	fmt.Println("control API:", controlURL(cfg))
+
 	// wait for all servers to be ready to serve requests.
 	fmt.Printf("\nWaiting %d seconds for servers to be up and running...\n", cfg.WaitTimeForServers)
 	time.Sleep(time.Duration(cfg.WaitTimeForServers) * time.Second)
@@ -167,11 +427,10 @@ func run(logger *log.Logger, opts Options) error {
 
 	// statistics to be presented on screen.
 	stats := stats.New()
-	stats.SetRequestsPerSecond(opts.RequestsPerSecond)
-	stats.SetTotalAvailableServers(len(serverConfigs))
+	stats.SetTotalAvailableServers(len(cfg.DnsServers))
 
 	// screen.
-	screen, err := screen.New()
+	display, err := screen.New()
 	if err != nil {
 		return errors.Wrap(err, "initializing screen")
 	}
@@ -181,79 +440,192 @@ func run(logger *log.Logger, opts Options) error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// randomly update servers latencies.
-	go randomServerLatency(logger, cfg, servers)
-	// randomly stop/start servers.
-	go stopOrStartServer(logger, cfg, stats, servers)
+	// randomly update servers latencies and stop/start servers,
+	// recording what happened if asked to.
+	recorder := chaos.NewRecorder(seed)
+	if opts.RecordSchedule != "" {
+		defer func() {
+			if err := recorder.Save(opts.RecordSchedule); err != nil {
+				logger.Printf("main: %v\n", err)
+			}
+		}()
+	}
+	// the chaos, and the changes made through the control API, are applied one at a time.
+	var applyMux sync.Mutex
+	apply := func(e chaos.Event) {
+		applyMux.Lock()
+		defer applyMux.Unlock()
+		applyChaosEvent(logger, stats, servers, e)
+		recorder.Add(e)
+	}
+	chaosSwitch := &chaos.Switch{}
+	chaosStart := time.Now()
+	go chaos.Run(ctx, events, func(e chaos.Event) {
+		if chaosSwitch.Paused() {
+			logger.Printf("Skipping %s event of server %s, chaos is paused\n", e.Action, e.Server)
+			return
+		}
+		apply(e)
+	})
+	// a generator is told about the servers stopped and started by hand, so it doesn't stop
+	// them again, or change the latency of a server it doesn't know is running.
+	chaosGenerator, _ := events.(*chaos.Generator)
+	applyByHand := func(e chaos.Event) {
+		if chaosGenerator != nil {
+			chaosGenerator.Observe(e)
+		}
+		apply(e)
+	}
+	api := control.New(servers, chaosSwitch, func(e chaos.Event) {
+		e.At = chaos.Duration(time.Since(chaosStart).Round(time.Millisecond))
+		logger.Printf("Control API: %s event of server %s\n", e.Action, e.Server)
+		applyByHand(e)
+	})
 
-	// Start the metrics server.
+	// Start the metrics server, and the control API.
 	go metricsServer(cfg)
+	go controlServer(cfg, api)
+
+	// changing the servers, the chaos and the rate from the keyboard.
+	if err := display.Control(&screen.Controls{
+		Servers: orderedServers,
+		Chaos:   chaosSwitch,
+		Profile: adjustable,
+		Apply:   applyByHand,
+		Stop: func() {
+			select {
+			case shutdown <- os.Interrupt:
+			default:
+			}
+		},
+		Logger: logger,
+	}); err != nil {
+		return errors.Wrap(err, "listening to keys")
+	}
 
 	start := time.Now()
+	timeline := report.NewTimeline(start, reportInterval)
 
 	// displaying stats on screen.
 	go func() {
 		for {
 			time.Sleep(time.Second * time.Duration(1))
 			stats.UpdateElapsedTime(time.Since(start))
-			screen.UpdateContent(stats, false)
+			display.UpdateContent(stats, false)
 		}
 	}()
 
-	// using a worker pool to perform DNS requests.
-	maxGoRoutines := runtime.GOMAXPROCS(0)
-	pool := task.New(ctx, maxGoRoutines)
-	worker := &worker.Worker{
-		Domain: cfg.Domain,
-		Digger: digger.New(logger, cfg.CorednsHost),
-		Logger: logger,
-		Stats:  stats,
+	// generating DNS requests in an open loop.
+	generator := load.New(adjustable, opts.MaxInFlight, stats)
+	d, err := digger.NewWithTransport(logger, cfg.CorednsHost, opts.Transport, tlsConfig)
+	if err != nil {
+		return errors.Wrap(err, "preparing digger")
 	}
-
-	var counter int32
-	go func() {
-		ticker := time.NewTicker(time.Second / time.Duration(opts.RequestsPerSecond))
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				pool.Do(worker)
-				stats.IncrTotalDnsRequests()
-				atomic.AddInt32(&counter, 1)
-
-				if opts.NumberOfDigs != -1 && int(atomic.LoadInt32(&counter)) >= opts.NumberOfDigs {
-					cancel()
-					return
-				}
-				if opts.TestTime != -1 && time.Since(start) >= time.Duration(opts.TestTime)*time.Second {
-					cancel()
-					return
-				}
-			case <-ctx.Done():
-				return
-			}
+	var sender load.Sender = &worker.Worker{
+		Domain:   cfg.Domain,
+		Digger:   d,
+		Logger:   logger,
+		Stats:    stats,
+		Timeline: timeline,
+	}
+	if len(targets) > 0 {
+		sender = &compare.Worker{
+			Domain:   cfg.Domain,
+			Targets:  targets,
+			Logger:   logger,
+			Stats:    stats,
+			Timeline: timeline,
 		}
+	}
+	var limit load.Limit
+	if opts.NumberOfDigs != -1 {
+		limit.Requests = opts.NumberOfDigs
+	}
+	if opts.TestTime != -1 {
+		limit.Duration = time.Duration(opts.TestTime) * time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		generator.Run(ctx, sender, limit)
+		close(done)
 	}()
 
-	// Wait for any error or interrupt signal.
+	// Wait for all requests to be sent and answered, or an interrupt signal.
 	select {
 	case <-shutdown:
-		screen.UpdateContent(stats, true)
-		closeOnce.Do(func() {
-			close(shutdown)
-			pool.Shutdown()
-		})
-	case <-ctx.Done():
-		screen.UpdateContent(stats, true)
-		closeOnce.Do(func() {
-			close(shutdown)
-			pool.Shutdown()
-		})
+		cancel()
+		<-done
+	case <-done:
 	}
+	display.UpdateContent(stats, true)
+
+	// side-by-side report of the compared policies.
+	var comparison [][]string
+	if len(targets) > 0 {
+		comparison = compare.Report(targets)
+		fmt.Println()
+		if err := pterm.DefaultTable.WithHasHeader().WithData(comparison).Render(); err != nil {
+			return errors.Wrap(err, "rendering comparison report")
+		}
+	}
+
+	// summary of the run.
+	policies, err := policiesUnderTest(opts, targets)
+	if err != nil {
+		return err
+	}
+	summary := &report.Report{
+		Options:        opts,
+		Config:         cfg,
+		Policies:       policies,
+		Seed:           seed,
+		Start:          start,
+		Duration:       chaos.Duration(time.Since(start).Round(time.Millisecond)),
+		Queries:        stats.TotalDnsRequests(),
+		FailedQueries:  stats.TotalFailedDnsRequests(),
+		LateQueries:    stats.TotalLateDnsRequests(),
+		DroppedQueries: stats.TotalDroppedDnsRequests(),
+		Latency: report.Latency{
+			P50: chaos.Duration(stats.DnsQueryDurationPercentile(50)),
+			P95: chaos.Duration(stats.DnsQueryDurationPercentile(95)),
+			P99: chaos.Duration(stats.DnsQueryDurationPercentile(99)),
+			Max: chaos.Duration(stats.MaxDnsQueryDuration()),
+		},
+		Comparison: comparison,
+		Shares:     timeline.Shares(),
+		Events:     recorder.Events(),
+	}
+	if err := summary.Save(opts.Report); err != nil {
+		return errors.Wrap(err, "saving report")
+	}
+	fmt.Printf("\nreport: %s.json, %s.html\n", opts.Report, opts.Report)
 	return nil
 }
 
+// policiesUnderTest returns the policies of the Corefiles CoreDNS runs with inside the tester,
+// or none if it runs outside.
+func policiesUnderTest(opts Options, targets []*compare.Target) ([]string, error) {
+	if len(targets) > 0 {
+		policies := make([]string, len(targets))
+		for i, t := range targets {
+			policies[i] = t.Name
+		}
+		return policies, nil
+	}
+	if opts.Corefile == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(opts.Corefile)
+	if err != nil {
+		return nil, errors.Wrapf(err, `reading corefile "%s"`, opts.Corefile)
+	}
+	policy := corefile.Policy(string(b))
+	if policy == "" {
+		policy = defaultPolicy
+	}
+	return []string{policy}, nil
+}
+
 func main() {
 	var opts Options
 	flags.Parse(&opts)
@@ -261,6 +633,32 @@ func main() {
 		fmt.Println("Error: You must provide either --number-of-digs or --test-time, not both or none.")
 		os.Exit(1)
 	}
+	chaosOpts := 0
+	for _, set := range []bool{opts.Seed != nil, opts.ReplaySchedule != "", opts.Scenario != ""} {
+		if set {
+			chaosOpts++
+		}
+	}
+	if opts.Corefile != "" && len(opts.Compare) > 0 {
+		fmt.Println("Error: You must provide either --corefile or --compare, not both.")
+		os.Exit(1)
+	}
+	if len(opts.Compare) == 1 {
+		fmt.Println("Error: You must provide --compare at least twice, once for each policy to compare.")
+		os.Exit(1)
+	}
+	if chaosOpts > 1 {
+		fmt.Println("Error: You must provide at most one of --seed, --replay-schedule or --scenario.")
+		os.Exit(1)
+	}
+	knownTransport := false
+	for _, transport := range digger.Transports {
+		knownTransport = knownTransport || transport == opts.Transport
+	}
+	if !knownTransport {
+		fmt.Printf("Error: Unknown transport %q, must be one of %s.\n", opts.Transport, strings.Join(digger.Transports, ", "))
+		os.Exit(1)
+	}
 	logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		fmt.Printf(`opening log file "%s": %v`, logFileName, err)
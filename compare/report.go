@@ -0,0 +1,60 @@
+package compare
+
+import (
+	"fmt"
+	"time"
+)
+
+// Report returns a side-by-side table of the results of each target: its latency percentiles,
+// failure rate and the share of the queries each upstream answered. The first row is the header.
+func Report(targets []*Target) [][]string {
+	upstreams := upstreamNames(targets)
+
+	header := []string{"Policy", "Corefile", "Queries", "Failures", "p50", "p95", "p99"}
+	for _, u := range upstreams {
+		if u == "" {
+			u = "unknown"
+		}
+		header = append(header, u)
+	}
+	rows := [][]string{header}
+
+	for _, t := range targets {
+		t.mux.Lock()
+		failures := t.failures
+		answers := 0
+		shares := make([]int, len(upstreams))
+		for i, u := range upstreams {
+			shares[i] = t.upstreams[u]
+			answers += shares[i]
+		}
+		t.mux.Unlock()
+
+		total := answers + failures
+		row := []string{
+			t.Name,
+			t.Corefile,
+			fmt.Sprintf("%d", total),
+			percentage(failures, total),
+			formatLatency(t.latencies.Percentile(50)),
+			formatLatency(t.latencies.Percentile(95)),
+			formatLatency(t.latencies.Percentile(99)),
+		}
+		for _, share := range shares {
+			row = append(row, percentage(share, answers))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func percentage(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+func formatLatency(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
@@ -0,0 +1,69 @@
+package compare
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/digger"
+	"github.com/tiagomelo/ewma-policy-poc/histogram"
+)
+
+func newTestTarget(name string) *Target {
+	return &Target{Name: name, Corefile: name + ".Corefile", latencies: histogram.New(), upstreams: make(map[string]int)}
+}
+
+func TestReport(t *testing.T) {
+	ewma := newTestTarget("ewma")
+	for i := 1; i <= 4; i++ {
+		ewma.record(time.Duration(i)*10*time.Millisecond, &digger.Result{Upstream: "server1"}, nil)
+	}
+	ewma.record(0, nil, errors.New("timeout"))
+
+	p2c := newTestTarget("p2c_ewma")
+	p2c.record(5*time.Millisecond, &digger.Result{Upstream: "server2"}, nil)
+	p2c.record(15*time.Millisecond, &digger.Result{}, nil)
+
+	idle := newTestTarget("random")
+
+	rows := Report([]*Target{ewma, p2c, idle})
+	// percentiles are the highest latency of their histogram bucket.
+	expected := [][]string{
+		{"Policy", "Corefile", "Queries", "Failures", "p50", "p95", "p99", "unknown", "server1", "server2"},
+		{"ewma", "ewma.Corefile", "5", "20.0%", "20.2ms", "40ms", "40ms", "0.0%", "100.0%", "0.0%"},
+		{"p2c_ewma", "p2c_ewma.Corefile", "2", "0.0%", "5.1ms", "15ms", "15ms", "50.0%", "0.0%", "50.0%"},
+		{"random", "random.Corefile", "0", "-", "0s", "0s", "0s", "-", "-", "-"},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d: %v", len(expected), len(rows), rows)
+	}
+	for i := range expected {
+		if strings.Join(rows[i], " | ") != strings.Join(expected[i], " | ") {
+			t.Errorf("Expected row %d to be %v, got %v", i, expected[i], rows[i])
+		}
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	targets := []*Target{
+		{Name: "latency", Corefile: "conf/LatencyCorefile", Addr: "127.0.0.1:1054"},
+		{Name: "latency", Corefile: "conf/SlowLatencyCorefile", Addr: "127.0.0.1:1055"},
+		{Name: "random", Corefile: "conf/Corefile", Addr: "127.0.0.1:1056"},
+		{Name: "p2c_ewma", Corefile: "a/P2CCorefile", Addr: "127.0.0.1:1057"},
+		{Name: "p2c_ewma", Corefile: "b/P2CCorefile", Addr: "127.0.0.1:1058"},
+	}
+	uniqueNames(targets)
+	expected := []string{
+		"latency (LatencyCorefile)",
+		"latency (SlowLatencyCorefile)",
+		"random",
+		"p2c_ewma (P2CCorefile) (127.0.0.1:1057)",
+		"p2c_ewma (P2CCorefile) (127.0.0.1:1058)",
+	}
+	for i, target := range targets {
+		if target.Name != expected[i] {
+			t.Errorf("Expected target %d to be named %q, got %q", i, expected[i], target.Name)
+		}
+	}
+}
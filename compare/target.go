@@ -0,0 +1,206 @@
+// Package compare runs several CoreDNS targets side by side, one per Corefile,
+// so their policies can be compared under the same queries and the same chaos.
+package compare
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/ewma-policy-poc/corefile"
+	"github.com/tiagomelo/ewma-policy-poc/digger"
+	"github.com/tiagomelo/ewma-policy-poc/histogram"
+)
+
+// Target is a CoreDNS process running one of the compared Corefiles.
+type Target struct {
+	// Name identifies the target in the report, it is the policy of its Corefile,
+	// followed by the Corefile when another target has the same policy.
+	Name     string
+	Corefile string
+	Addr     string
+
+	cmd    *exec.Cmd
+	digger *digger.Digger
+
+	// latencies are those of the answered queries.
+	latencies *histogram.Histogram
+	mux       sync.Mutex
+	failures  int
+	upstreams map[string]int
+}
+
+// Build builds the CoreDNS binary from the sources in dir, and writes it to output.
+func Build(dir, output string) error {
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return errors.Wrapf(err, `resolving path "%s"`, output)
+	}
+	cmd := exec.Command("go", "build", "-o", output, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "building coredns: %s", out)
+	}
+	return nil
+}
+
+// Config describes the CoreDNS targets to start.
+type Config struct {
+	// Binary is the CoreDNS binary the targets run.
+	Binary string
+	// Corefiles are the Corefiles of the targets, one each.
+	Corefiles []string
+	// Upstreams are the addresses the targets forward to, or TLSUpstreams
+	// if their Corefile forwards over TLS.
+	Upstreams    []string
+	TLSUpstreams []string
+	// Each target listens on Host, on the port following BasePort, and exports
+	// its metrics on the port following MetricsBasePort.
+	Host            string
+	BasePort        int
+	MetricsBasePort int
+	// Transport and TLSConfig are how the targets are queried, see digger.NewWithTransport.
+	Transport string
+	TLSConfig *tls.Config
+}
+
+// Start starts a CoreDNS target for each of the Corefiles in cfg.
+func Start(logger *log.Logger, cfg Config) ([]*Target, error) {
+	targets := make([]*Target, 0, len(cfg.Corefiles))
+	for i, path := range cfg.Corefiles {
+		t, err := start(logger, cfg, path, cfg.BasePort+i+1, cfg.MetricsBasePort+i+1)
+		if err != nil {
+			Stop(targets)
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	uniqueNames(targets)
+	return targets, nil
+}
+
+// uniqueNames tells apart the targets whose Corefiles have the same policy, by adding the
+// base name of their Corefile to their name, or their address if that's the same too.
+func uniqueNames(targets []*Target) {
+	for _, suffix := range []func(*Target) string{
+		func(t *Target) string { return filepath.Base(t.Corefile) },
+		func(t *Target) string { return t.Addr },
+	} {
+		count := make(map[string]int)
+		for _, t := range targets {
+			count[t.Name]++
+		}
+		for _, t := range targets {
+			if count[t.Name] > 1 {
+				t.Name = fmt.Sprintf("%s (%s)", t.Name, suffix(t))
+			}
+		}
+	}
+}
+
+func start(logger *log.Logger, cfg Config, path string, port, metricsPort int) (*Target, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `reading corefile "%s"`, path)
+	}
+	name := corefile.Policy(string(b))
+	if name == "" {
+		name = filepath.Base(path)
+	}
+
+	// every target needs its own ports and forwards to the configured DNS servers,
+	// the rest of the Corefile is left as is.
+	conf := corefile.SetMetricsPort(corefile.SetPort(string(b), port), metricsPort)
+	upstreams := cfg.Upstreams
+	if corefile.ForwardsOverTLS(conf) {
+		upstreams = cfg.TLSUpstreams
+	}
+	conf = corefile.SetUpstreams(conf, upstreams)
+
+	baseName := fmt.Sprintf("logs/compare_%d_%s", port, name)
+	confFile := baseName + ".Corefile"
+	if err := os.WriteFile(confFile, []byte(conf), 0644); err != nil {
+		return nil, errors.Wrapf(err, `writing corefile "%s"`, confFile)
+	}
+	logFile, err := os.OpenFile(baseName+".txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, `opening log file "%s"`, baseName+".txt")
+	}
+	cmd := exec.Command(cfg.Binary, "-conf", confFile)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, errors.Wrapf(err, `starting coredns with corefile "%s"`, path)
+	}
+	go func() {
+		cmd.Wait()
+		logFile.Close()
+	}()
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	d, err := digger.NewWithTransport(logger, addr, cfg.Transport, cfg.TLSConfig)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	logger.Printf("compare: started coredns with %s policy from %s on %s\n", name, path, addr)
+	return &Target{
+		Name:      name,
+		Corefile:  path,
+		Addr:      addr,
+		cmd:       cmd,
+		digger:    d,
+		latencies: histogram.New(),
+		upstreams: make(map[string]int),
+	}, nil
+}
+
+// Stop stops the CoreDNS processes of the given targets.
+func Stop(targets []*Target) {
+	for _, t := range targets {
+		if t.cmd.Process != nil {
+			t.cmd.Process.Kill()
+		}
+	}
+}
+
+// record records the outcome of a query to the target, latency is measured from the
+// intended send time of the query.
+func (t *Target) record(latency time.Duration, result *digger.Result, err error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if err != nil {
+		t.failures++
+		return
+	}
+	t.latencies.Record(latency)
+	t.upstreams[result.Upstream]++
+}
+
+// upstreamNames returns the names of all upstreams that answered any of the targets, sorted.
+func upstreamNames(targets []*Target) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, t := range targets {
+		t.mux.Lock()
+		for name := range t.upstreams {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		t.mux.Unlock()
+	}
+	sort.Strings(names)
+	return names
+}
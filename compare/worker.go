@@ -0,0 +1,86 @@
+package compare
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tiagomelo/ewma-policy-poc/digger"
+	"github.com/tiagomelo/ewma-policy-poc/report"
+	"github.com/tiagomelo/ewma-policy-poc/screen/stats"
+)
+
+var (
+	comparedDnsRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "compared_dns_requests_total",
+			Help: "Number of DNS requests sent to each compared policy.",
+		},
+		[]string{"policy"},
+	)
+	comparedFailedDnsRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "compared_failed_dns_requests_total",
+			Help: "Number of failed DNS requests to each compared policy.",
+		},
+		[]string{"policy"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(comparedDnsRequests)
+	prometheus.MustRegister(comparedFailedDnsRequests)
+}
+
+// Worker sends the same query to all targets at the same moment. Each target counts its own
+// answers and failures for the comparison, Stats count each query once, for all targets:
+// as failed if any of them failed, taking as long as the slowest of them.
+type Worker struct {
+	Domain  string
+	Targets []*Target
+	Logger  *log.Logger
+	Stats   *stats.Statistics
+	// Timeline, if set, counts the answers of each upstream over time,
+	// as "policy/upstream".
+	Timeline *report.Timeline
+}
+
+// Send implements load.Sender, it digs the domain with every target.
+func (w *Worker) Send(ctx context.Context, intended time.Time) {
+	var wg sync.WaitGroup
+	var failed int32
+	wg.Add(len(w.Targets))
+	for _, t := range w.Targets {
+		go func(t *Target) {
+			defer wg.Done()
+			result, err := t.digger.Query(w.Domain)
+			latency := time.Since(intended)
+			t.record(latency, result, err)
+			digger.Observe(latency, err)
+			if err == nil {
+				w.Stats.RecordUpstreamAnswer(result.Upstream, latency)
+			}
+			if err == nil && w.Timeline != nil {
+				upstream := result.Upstream
+				if upstream == "" {
+					upstream = report.Unknown
+				}
+				w.Timeline.Add(time.Now(), t.Name+"/"+upstream)
+			}
+			comparedDnsRequests.With(prometheus.Labels{"policy": t.Name}).Inc()
+			if err != nil {
+				w.Logger.Printf(`error when digging domain "%s" with %s policy: %v`, w.Domain, t.Name, err)
+				atomic.StoreInt32(&failed, 1)
+				comparedFailedDnsRequests.With(prometheus.Labels{"policy": t.Name}).Inc()
+			}
+		}(t)
+	}
+	wg.Wait()
+	w.Stats.RecordDnsQueryDuration(time.Since(intended))
+	if failed != 0 {
+		w.Stats.IncrTotalFailedDnsRequests()
+	}
+}
@@ -10,6 +10,8 @@ import (
 type Config struct {
 	// metrics server.
 	MetricsServerPort int `envconfig:"METRICS_SERVER_PORT" required:"true"`
+	// control API, which has no auth: on loopback only unless set to listen elsewhere.
+	ControlApiAddr string `envconfig:"CONTROL_API_ADDR" default:"127.0.0.1:2113"`
 
 	// Prometheus.
 	PromTemplateFile     string `envconfig:"PROM_TEMPLATE_FILE" required:"true"`
@@ -25,13 +27,20 @@ type Config struct {
 	CorednsHost string `envconfig:"COREDNS_HOST" required:"true"`
 	Domain      string `envconfig:"DOMAIN" required:"true"`
 
+	// Corefiles, generated from templates to forward to the DNS servers.
+	CorefileTemplateDir string `envconfig:"COREFILE_TEMPLATE_DIR" required:"true"`
+	CorefileOutputDir   string `envconfig:"COREFILE_OUTPUT_DIR" required:"true"`
+
 	// DNS servers.
-	DnsServer1Name string `envconfig:"DNS_SERVER_1_NAME" required:"true"`
-	DnsServer1Port int    `envconfig:"DNS_SERVER_1_PORT" required:"true"`
-	DnsServer2Name string `envconfig:"DNS_SERVER_2_NAME" required:"true"`
-	DnsServer2Port int    `envconfig:"DNS_SERVER_2_PORT" required:"true"`
-	DnsServer3Name string `envconfig:"DNS_SERVER_3_NAME" required:"true"`
-	DnsServer3Port int    `envconfig:"DNS_SERVER_3_PORT" required:"true"`
+	DnsServers Upstreams `envconfig:"DNS_SERVERS" required:"true"`
+	// Latency models of the DNS servers, replacing their constant latency.
+	DnsServerLatencyModels LatencyModels `envconfig:"DNS_SERVER_LATENCY_MODELS"`
+	// DNS over TLS and over HTTPS listeners of the DNS servers, on their port plus an offset,
+	// with a self-signed certificate generated into the cert and key files if they don't exist.
+	DnsServerTLSPortOffset   int    `envconfig:"DNS_SERVER_TLS_PORT_OFFSET" required:"true"`
+	DnsServerHTTPSPortOffset int    `envconfig:"DNS_SERVER_HTTPS_PORT_OFFSET" required:"true"`
+	DnsServerCertFile        string `envconfig:"DNS_SERVER_CERT_FILE" required:"true"`
+	DnsServerKeyFile         string `envconfig:"DNS_SERVER_KEY_FILE" required:"true"`
 
 	// Random server latency.
 	RslPeriodInSeconds int `envconfig:"RSL_PERIOD_IN_SECONDS" required:"true"`
@@ -61,5 +70,8 @@ func Read() (*Config, error) {
 	if err := envconfigProcess("", config); err != nil {
 		return nil, errors.Wrap(err, "processing env vars")
 	}
+	if err := config.DnsServers.setTransportPorts(config.DnsServerTLSPortOffset, config.DnsServerHTTPSPortOffset); err != nil {
+		return nil, errors.Wrap(err, "processing env vars")
+	}
 	return config, nil
 }
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LatencyModels are the latency models of the DNS servers, by name, read from a
+// comma separated list of name=model, like "server2=lognormal:20ms:0.5". Each
+// model is a spec dnsserver.ParseLatencyModel reads. Servers not in the list
+// keep the constant latency of DNS_SERVERS.
+type LatencyModels map[string]string
+
+// Decode implements envconfig.Decoder.
+func (l *LatencyModels) Decode(value string) error {
+	models := make(LatencyModels)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, model, ok := strings.Cut(field, "=")
+		name, model = strings.TrimSpace(name), strings.TrimSpace(model)
+		if !ok || name == "" || model == "" {
+			return fmt.Errorf(`parsing latency model "%s": expected name=model`, field)
+		}
+		if _, ok := models[name]; ok {
+			return fmt.Errorf(`server "%s" has more than one latency model`, name)
+		}
+		models[name] = model
+	}
+	*l = models
+	return nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLatencyModelsDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    LatencyModels
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: LatencyModels{}},
+		{
+			name:  "models",
+			value: "server1=lognormal:20ms:0.5, server2=empirical:latencies/resolver.txt",
+			want: LatencyModels{
+				"server1": "lognormal:20ms:0.5",
+				"server2": "empirical:latencies/resolver.txt",
+			},
+		},
+		{name: "missing model", value: "server1=", wantErr: true},
+		{name: "missing name", value: "=constant:1ms", wantErr: true},
+		{name: "missing separator", value: "server1", wantErr: true},
+		{name: "duplicated name", value: "server1=constant:1ms,server1=constant:2ms", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got LatencyModels
+			err := got.Decode(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Upstream is a DNS server the tester runs for CoreDNS to forward to.
+type Upstream struct {
+	Name string
+	Port int
+	// TLSPort and HTTPSPort are where the server answers DNS over TLS and over HTTPS.
+	TLSPort   int
+	HTTPSPort int
+	// Latency is how long the server takes to answer, before any chaos changes it.
+	Latency time.Duration
+	// Jitter is the most that is randomly added to the latency of each answer.
+	Jitter time.Duration
+}
+
+// Upstreams is a list of upstreams, read from a comma separated list of
+// name:port[:latency_ms[:jitter_ms]], like "server1:8051,server2:8052:20:5".
+// The latency is 1ms and there's no jitter unless given.
+type Upstreams []Upstream
+
+// Decode implements envconfig.Decoder.
+func (u *Upstreams) Decode(value string) error {
+	var upstreams Upstreams
+	names := make(map[string]bool)
+	ports := make(map[int]bool)
+	for _, field := range strings.Split(value, ",") {
+		upstream, err := parseUpstream(strings.TrimSpace(field))
+		if err != nil {
+			return errors.Wrapf(err, `parsing upstream "%s"`, field)
+		}
+		if names[upstream.Name] {
+			return fmt.Errorf(`upstream name "%s" is used more than once`, upstream.Name)
+		}
+		if ports[upstream.Port] {
+			return fmt.Errorf("upstream port %d is used more than once", upstream.Port)
+		}
+		names[upstream.Name] = true
+		ports[upstream.Port] = true
+		upstreams = append(upstreams, upstream)
+	}
+	*u = upstreams
+	return nil
+}
+
+// Addrs returns the addresses of the upstreams, for CoreDNS to forward to.
+func (u Upstreams) Addrs() []string {
+	addrs := make([]string, len(u))
+	for i, upstream := range u {
+		addrs[i] = fmt.Sprintf("127.0.0.1:%d", upstream.Port)
+	}
+	return addrs
+}
+
+// TLSAddrs returns the DNS over TLS addresses of the upstreams, for CoreDNS to forward to.
+func (u Upstreams) TLSAddrs() []string {
+	addrs := make([]string, len(u))
+	for i, upstream := range u {
+		addrs[i] = fmt.Sprintf("tls://127.0.0.1:%d", upstream.TLSPort)
+	}
+	return addrs
+}
+
+// setTransportPorts sets the TLS and HTTPS ports of the upstreams to their port
+// plus the given offsets, checking no two listeners share a port.
+func (u Upstreams) setTransportPorts(tlsOffset, httpsOffset int) error {
+	if tlsOffset <= 0 || httpsOffset <= 0 || tlsOffset == httpsOffset {
+		return fmt.Errorf("TLS and HTTPS port offsets must be different and greater than 0: %d, %d", tlsOffset, httpsOffset)
+	}
+	ports := make(map[int]string)
+	for _, upstream := range u {
+		ports[upstream.Port] = upstream.Name
+	}
+	for i := range u {
+		u[i].TLSPort = u[i].Port + tlsOffset
+		u[i].HTTPSPort = u[i].Port + httpsOffset
+		for _, port := range []int{u[i].TLSPort, u[i].HTTPSPort} {
+			if port > 65535 {
+				return fmt.Errorf(`upstream "%s" port %d plus offset is out of range`, u[i].Name, u[i].Port)
+			}
+			if name, ok := ports[port]; ok {
+				return fmt.Errorf(`upstream "%s" port %d plus offset is already used by upstream "%s"`, u[i].Name, u[i].Port, name)
+			}
+			ports[port] = u[i].Name
+		}
+	}
+	return nil
+}
+
+func parseUpstream(field string) (Upstream, error) {
+	parts := strings.Split(field, ":")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] == "" {
+		return Upstream{}, errors.New("expected name:port[:latency_ms[:jitter_ms]]")
+	}
+	upstream := Upstream{Name: parts[0], Latency: time.Millisecond}
+	port, err := strconv.Atoi(parts[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return Upstream{}, fmt.Errorf(`invalid port "%s"`, parts[1])
+	}
+	upstream.Port = port
+	if len(parts) > 2 {
+		if upstream.Latency, err = parseMilliseconds(parts[2]); err != nil {
+			return Upstream{}, errors.Wrap(err, "parsing latency")
+		}
+	}
+	if len(parts) > 3 {
+		if upstream.Jitter, err = parseMilliseconds(parts[3]); err != nil {
+			return Upstream{}, errors.Wrap(err, "parsing jitter")
+		}
+	}
+	return upstream, nil
+}
+
+func parseMilliseconds(s string) (time.Duration, error) {
+	ms, err := strconv.Atoi(s)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf(`invalid number of milliseconds "%s"`, s)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
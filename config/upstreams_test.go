@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpstreamsDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Upstreams
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			value: "server1:8051,server2:8052",
+			want: Upstreams{
+				{Name: "server1", Port: 8051, Latency: time.Millisecond},
+				{Name: "server2", Port: 8052, Latency: time.Millisecond},
+			},
+		},
+		{
+			name:  "latency and jitter",
+			value: "server1:8051:20, server2:8052:30:10",
+			want: Upstreams{
+				{Name: "server1", Port: 8051, Latency: 20 * time.Millisecond},
+				{Name: "server2", Port: 8052, Latency: 30 * time.Millisecond, Jitter: 10 * time.Millisecond},
+			},
+		},
+		{name: "missing port", value: "server1", wantErr: true},
+		{name: "invalid port", value: "server1:http", wantErr: true},
+		{name: "negative latency", value: "server1:8051:-1", wantErr: true},
+		{name: "too many fields", value: "server1:8051:1:1:1", wantErr: true},
+		{name: "duplicated name", value: "server1:8051,server1:8052", wantErr: true},
+		{name: "duplicated port", value: "server1:8051,server2:8051", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Upstreams
+			err := got.Decode(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestUpstreamsSetTransportPorts(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		tlsOffset   int
+		httpsOffset int
+		wantTLS     []int
+		wantHTTPS   []int
+		wantErr     bool
+	}{
+		{
+			name:        "offsets",
+			value:       "server1:8051,server2:8052",
+			tlsOffset:   1000,
+			httpsOffset: 2000,
+			wantTLS:     []int{9051, 9052},
+			wantHTTPS:   []int{10051, 10052},
+		},
+		{name: "no offset", value: "server1:8051", tlsOffset: 0, httpsOffset: 2000, wantErr: true},
+		{name: "same offsets", value: "server1:8051", tlsOffset: 1000, httpsOffset: 1000, wantErr: true},
+		{name: "port clash", value: "server1:8051,server2:9051", tlsOffset: 1000, httpsOffset: 2000, wantErr: true},
+		{name: "out of range", value: "server1:65000", tlsOffset: 1000, httpsOffset: 2000, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var upstreams Upstreams
+			if err := upstreams.Decode(tc.value); err != nil {
+				t.Fatalf("Expected no error decoding %q, got %v", tc.value, err)
+			}
+			err := upstreams.setTransportPorts(tc.tlsOffset, tc.httpsOffset)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", upstreams)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			for i, upstream := range upstreams {
+				if upstream.TLSPort != tc.wantTLS[i] || upstream.HTTPSPort != tc.wantHTTPS[i] {
+					t.Errorf("Expected %s on TLS port %d and HTTPS port %d, got %d and %d",
+						upstream.Name, tc.wantTLS[i], tc.wantHTTPS[i], upstream.TLSPort, upstream.HTTPSPort)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// Package control is an HTTP/JSON API to change the DNS servers while a run is going:
+// their latency, latency model and faults, and whether they are up, and to pause or
+// resume the random chaos. Changes are made as chaos events, so they show up in the
+// report and in a recorded schedule like any other.
+//
+//	GET  /servers                 lists the servers
+//	GET  /servers/{name}          shows a server
+//	POST /servers/{name}/latency  {"latency": "50ms"}
+//	POST /servers/{name}/model    {"model": "lognormal:20ms:0.5"}
+//	POST /servers/{name}/fault    {"mode": "drop", "rate": 0.5}, a rate of 0 clears it
+//	POST /servers/{name}/stop
+//	POST /servers/{name}/start
+//	GET  /chaos                   tells whether the chaos is paused
+//	POST /chaos/pause
+//	POST /chaos/resume
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+)
+
+// Server is the state of a DNS server.
+type Server struct {
+	Name    string         `json:"name"`
+	Port    int            `json:"port"`
+	Running bool           `json:"running"`
+	Latency chaos.Duration `json:"latency"`
+	Model   string         `json:"model"`
+	Jitter  chaos.Duration `json:"jitter"`
+	Faults  []Fault        `json:"faults"`
+}
+
+// Fault is a fault of a DNS server, see dnsserver.Fault.
+type Fault struct {
+	Mode  string         `json:"mode"`
+	Rate  float64        `json:"rate"`
+	Delay chaos.Duration `json:"delay,omitempty"`
+}
+
+// Chaos is the state of the random chaos.
+type Chaos struct {
+	Paused bool `json:"paused"`
+}
+
+// API answers the requests of the control API.
+type API struct {
+	servers map[string]*dnsserver.Server
+	names   []string
+	chaos   *chaos.Switch
+	apply   func(chaos.Event)
+}
+
+// New creates an API for the given servers and chaos switch. Changes to the servers are
+// handed to apply as chaos events, once validated.
+func New(servers map[string]*dnsserver.Server, chaos *chaos.Switch, apply func(chaos.Event)) *API {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &API{servers: servers, names: names, chaos: chaos, apply: apply}
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "servers":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		servers := make([]Server, len(a.names))
+		for i, name := range a.names {
+			servers[i] = state(a.servers[name])
+		}
+		writeJSON(w, http.StatusOK, servers)
+	case len(parts) == 2 && parts[0] == "servers":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		if server, ok := a.server(w, parts[1]); ok {
+			writeJSON(w, http.StatusOK, state(server))
+		}
+	case len(parts) == 3 && parts[0] == "servers":
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		if server, ok := a.server(w, parts[1]); ok {
+			a.changeServer(w, r, server, parts[2])
+		}
+	case len(parts) == 1 && parts[0] == "chaos":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, Chaos{Paused: a.chaos.Paused()})
+	case len(parts) == 2 && parts[0] == "chaos" && (parts[1] == "pause" || parts[1] == "resume"):
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		if parts[1] == "pause" {
+			a.chaos.Pause()
+		} else {
+			a.chaos.Resume()
+		}
+		writeJSON(w, http.StatusOK, Chaos{Paused: a.chaos.Paused()})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf(`unknown path "%s"`, r.URL.Path))
+	}
+}
+
+// server returns the server with the given name, answering 404 if there's none.
+func (a *API) server(w http.ResponseWriter, name string) (*dnsserver.Server, bool) {
+	server, ok := a.servers[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf(`unknown server "%s"`, name))
+	}
+	return server, ok
+}
+
+// changeServer applies the change of the given action to server, and answers its new state.
+func (a *API) changeServer(w http.ResponseWriter, r *http.Request, server *dnsserver.Server, action string) {
+	e := chaos.Event{Server: server.GetName()}
+	switch action {
+	case "latency":
+		var body struct {
+			Latency *chaos.Duration `json:"latency"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		if body.Latency == nil || *body.Latency < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf(`expected a latency like "50ms"`))
+			return
+		}
+		e.Action, e.Latency = chaos.SetLatency, *body.Latency
+	case "model":
+		var body struct {
+			Model string `json:"model"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		if _, err := dnsserver.ParseLatencyModel(body.Model); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		e.Action, e.Model = chaos.SetModel, body.Model
+	case "fault":
+		var body Fault
+		if !readJSON(w, r, &body) {
+			return
+		}
+		fault := dnsserver.Fault{Mode: dnsserver.FaultMode(body.Mode), Rate: body.Rate, Delay: time.Duration(body.Delay)}
+		if err := fault.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		e.Action, e.Fault, e.Rate, e.Delay = chaos.InjectFault, body.Mode, body.Rate, body.Delay
+	case "stop":
+		e.Action = chaos.Stop
+	case "start":
+		e.Action = chaos.Start
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf(`unknown action "%s"`, action))
+		return
+	}
+	a.apply(e)
+	writeJSON(w, http.StatusOK, state(server))
+}
+
+// state returns the current state of server.
+func state(server *dnsserver.Server) Server {
+	s := Server{
+		Name:    server.GetName(),
+		Port:    server.GetPort(),
+		Running: server.IsRunning(),
+		Latency: chaos.Duration(server.GetLatency()),
+		Model:   server.GetLatencyModel().String(),
+		Jitter:  chaos.Duration(server.GetJitter()),
+		Faults:  []Fault{},
+	}
+	for _, f := range server.GetFaults() {
+		s.Faults = append(s.Faults, Fault{Mode: string(f.Mode), Rate: f.Rate, Delay: chaos.Duration(f.Delay)})
+	}
+	return s
+}
+
+// allow answers 405 unless the request has the given method.
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed, use %s", r.Method, method))
+		return false
+	}
+	return true
+}
+
+// readJSON reads the body of the request into v, answering 400 if it can't.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,123 @@
+package control
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+)
+
+// newTestAPI returns an API for two servers that aren't running, and the events it applied.
+func newTestAPI() (*API, *[]chaos.Event) {
+	logger := log.New(io.Discard, "", 0)
+	servers := map[string]*dnsserver.Server{
+		"server2": dnsserver.NewServerWithLogger("server2", 8052, 20, logger),
+		"server1": dnsserver.NewServerWithLogger("server1", 8051, 1, logger),
+	}
+	var applied []chaos.Event
+	api := New(servers, &chaos.Switch{}, func(e chaos.Event) {
+		applied = append(applied, e)
+		if e.Action == chaos.SetLatency {
+			servers[e.Server].SetLatency(time.Duration(e.Latency))
+		}
+	})
+	return api, &applied
+}
+
+func do(api *API, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestListServers(t *testing.T) {
+	api, _ := newTestAPI()
+	w := do(api, http.MethodGet, "/servers", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	var servers []Server
+	if err := json.Unmarshal(w.Body.Bytes(), &servers); err != nil {
+		t.Fatalf("decoding servers: %v", err)
+	}
+	if len(servers) != 2 || servers[0].Name != "server1" || servers[1].Name != "server2" {
+		t.Fatalf("Expected server1 and server2, in order, got %+v", servers)
+	}
+	if got := time.Duration(servers[1].Latency); got != 20*time.Millisecond {
+		t.Errorf("Expected server2 to have a latency of 20ms, got %s", got)
+	}
+	if servers[1].Model != "constant:20ms" {
+		t.Errorf(`Expected server2 to have the model "constant:20ms", got "%s"`, servers[1].Model)
+	}
+}
+
+func TestSetLatency(t *testing.T) {
+	api, applied := newTestAPI()
+	w := do(api, http.MethodPost, "/servers/server2/latency", `{"latency": "300ms"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body)
+	}
+	expected := chaos.Event{Server: "server2", Action: chaos.SetLatency, Latency: chaos.Duration(300 * time.Millisecond)}
+	if len(*applied) != 1 || (*applied)[0] != expected {
+		t.Errorf("Expected the event %+v to be applied, got %+v", expected, *applied)
+	}
+	var server Server
+	if err := json.Unmarshal(w.Body.Bytes(), &server); err != nil {
+		t.Fatalf("decoding server: %v", err)
+	}
+	if got := time.Duration(server.Latency); got != 300*time.Millisecond {
+		t.Errorf("Expected the answer to show the new latency, 300ms, got %s", got)
+	}
+}
+
+func TestInvalidRequestsAreNotApplied(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPost, "/servers/server9/stop", "", http.StatusNotFound},
+		{http.MethodPost, "/servers/server1/reboot", "", http.StatusNotFound},
+		{http.MethodGet, "/servers/server1/stop", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/servers/server1/latency", `{"latency": 300}`, http.StatusBadRequest},
+		{http.MethodPost, "/servers/server1/latency", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/servers/server1/model", `{"model": "gamma:1ms"}`, http.StatusBadRequest},
+		{http.MethodPost, "/servers/server1/fault", `{"mode": "spike", "rate": 0.5}`, http.StatusBadRequest},
+		{http.MethodPost, "/servers/server1/fault", `{"mode": "drop", "rate": 2}`, http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		api, applied := newTestAPI()
+		if w := do(api, test.method, test.path, test.body); w.Code != test.status {
+			t.Errorf("Test %d: expected status %d, got %d: %s", i, test.status, w.Code, w.Body)
+		}
+		if len(*applied) > 0 {
+			t.Errorf("Test %d: expected no event to be applied, got %+v", i, *applied)
+		}
+	}
+}
+
+func TestPauseAndResumeChaos(t *testing.T) {
+	api, _ := newTestAPI()
+	for _, test := range []struct {
+		path   string
+		paused bool
+	}{
+		{"/chaos/pause", true},
+		{"/chaos/resume", false},
+	} {
+		w := do(api, http.MethodPost, test.path, "")
+		var state Chaos
+		if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+			t.Fatalf("decoding chaos state: %v", err)
+		}
+		if state.Paused != test.paused || api.chaos.Paused() != test.paused {
+			t.Errorf("Expected the chaos to be paused: %v after %s, got %v", test.paused, test.path, state.Paused)
+		}
+	}
+}
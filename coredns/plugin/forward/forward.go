@@ -145,11 +145,15 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		var (
 			ret *dns.Msg
 			err error
+			rtt time.Duration
 		)
 		opts := f.opts
 
 		for {
+			// rtt only covers this Connect, earlier attempts to other proxies are not charged to it.
+			connectStart := time.Now()
 			ret, err = proxy.Connect(ctx, state, opts)
+			rtt = time.Since(connectStart)
 			if err == ErrCachedClosed { // Remote side closed conn, can only happen with TCP.
 				continue
 			}
@@ -171,6 +175,16 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 
 		upstreamErr = err
 
+		// Let policies that learn from outcomes know how this attempt went.
+		// Failed attempts are observations too, otherwise a failing proxy keeps its last good value.
+		if o, ok := f.p.(Observer); ok {
+			rcode := dns.RcodeServerFailure
+			if ret != nil {
+				rcode = ret.Rcode
+			}
+			o.OnResult(proxy, rtt, rcode, err)
+		}
+
 		if err != nil {
 			// Kick off health check to see if *our* upstream is broken.
 			if f.maxfails != 0 {
@@ -193,12 +207,6 @@ func (f *Forward) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 			return 0, nil
 		}
 
-		// check if the active policy is our custom 'latency' policy.
-		if latency, ok := f.p.(*latency); ok {
-			// update the latency measurement for the proxy.
-			latency.OnComplete(proxy.Addr(), time.Since(start), r)
-		}
-
 		w.WriteMsg(ret)
 		return 0, nil
 	}
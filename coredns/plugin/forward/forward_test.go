@@ -1,15 +1,23 @@
 package forward
 
 import (
+	"context"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/caddy/caddyfile"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin/dnstap"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	pkglatency "github.com/coredns/coredns/plugin/pkg/latency"
 	"github.com/coredns/coredns/plugin/pkg/proxy"
 	"github.com/coredns/coredns/plugin/pkg/transport"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
 )
 
 func TestList(t *testing.T) {
@@ -74,3 +82,198 @@ func TestSetTapPlugin(t *testing.T) {
 		t.Error("Unexpected order of dnstap plugins")
 	}
 }
+
+// setDefaultTimeout sets how long ServeDNS keeps trying upstreams for the length of the test.
+// The health tests lower it for the whole package.
+func setDefaultTimeout(t *testing.T, d time.Duration) {
+	old := defaultTimeout
+	defaultTimeout = d
+	t.Cleanup(func() { defaultTimeout = old })
+}
+
+func TestLatencyPerAttemptRTT(t *testing.T) {
+	setDefaultTimeout(t, 5*time.Second)
+
+	// silent accepts queries but never answers them, so every attempt to it times out.
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . "+silent.LocalAddr().String()+" "+s.Addr+" {\npolicy latency\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f := fs[0]
+	readTimeout := 200 * time.Millisecond
+	for _, p := range f.proxies {
+		p.SetReadTimeout(readTimeout)
+	}
+	l := f.p.(*latency)
+	o := &observingPolicy{Policy: l}
+	f.p = o
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	// Make sure the silent upstream is tried first.
+	l.Penalties = pkglatency.Penalties{}
+	l.Observe(silent.LocalAddr().String(), time.Millisecond, dns.RcodeSuccess, nil)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	f.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+
+	if len(o.results) < 2 {
+		t.Fatalf("Expected an attempt to each upstream, got %+v", o.results)
+	}
+	if x := o.results[0]; x.addr != silent.LocalAddr().String() || x.err == nil || x.rtt < readTimeout {
+		t.Errorf("Expected the attempt to %s to time out after %s, got %+v", silent.LocalAddr(), readTimeout, x)
+	}
+	if x := o.results[1]; x.addr != s.Addr || x.err != nil || x.rtt >= readTimeout {
+		t.Errorf("Expected the attempt to %s not to be charged the previous timeout, got %+v", s.Addr, x)
+	}
+
+	if x, _ := l.EWMA(silent.LocalAddr().String()); x <= time.Millisecond {
+		t.Errorf("Expected the timed out attempt to raise the silent upstream's latency, got %s", x)
+	}
+	if x, ok := l.EWMA(s.Addr); !ok || x >= readTimeout {
+		t.Errorf("Expected the answering upstream's latency to be its own RTT, got %s", x)
+	}
+}
+
+// newUDPServer starts a DNS server on UDP with a handler of its own, unlike dnstest servers
+// which share the default one, and returns its address.
+func newUDPServer(t *testing.T, f dns.HandlerFunc) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s := &dns.Server{PacketConn: pc, Handler: f, NotifyStartedFunc: func() { close(started) }}
+	go s.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { s.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestLatencyFailingUpstreamDropsToEnd(t *testing.T) {
+	failing := newUDPServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Rcode = dns.RcodeServerFailure
+		w.WriteMsg(ret)
+	})
+	healthy := newUDPServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Answer = append(ret.Answer, test.A("example.org. IN A 127.0.0.1"))
+		w.WriteMsg(ret)
+	})
+
+	c := caddy.NewTestController("dns", "forward . "+failing+" "+healthy+" {\npolicy latency {\nrcode_penalty 1s\n}\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f := fs[0]
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	// The failing upstream starts out as the fastest one.
+	l := f.p.(*latency)
+	l.Observe(failing, time.Millisecond, dns.RcodeSuccess, nil)
+	l.Observe(healthy, 10*time.Millisecond, dns.RcodeSuccess, nil)
+	if x := f.List()[0].Addr(); x != failing {
+		t.Fatalf("Expected %s to be listed first, got %s", failing, x)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	f.ServeDNS(context.TODO(), rec, m)
+	if rec.Msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL from %s, got %s", failing, dns.RcodeToString[rec.Msg.Rcode])
+	}
+
+	list := f.List()
+	if x := list[len(list)-1].Addr(); x != failing {
+		t.Errorf("Expected failing upstream %s to be listed last, got %s", failing, x)
+	}
+}
+
+// observingPolicy records the results it is told about, and passes them on to Policy
+// if it observes them too.
+type observingPolicy struct {
+	Policy
+	results []observedResult
+}
+
+type observedResult struct {
+	addr  string
+	rtt   time.Duration
+	rcode int
+	err   error
+}
+
+func (o *observingPolicy) OnResult(p *proxy.Proxy, rtt time.Duration, rcode int, err error) {
+	o.results = append(o.results, observedResult{p.Addr(), rtt, rcode, err})
+	if inner, ok := o.Policy.(Observer); ok {
+		inner.OnResult(p, rtt, rcode, err)
+	}
+}
+
+func TestObserverOnResult(t *testing.T) {
+	setDefaultTimeout(t, 5*time.Second)
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		ret.Rcode = dns.RcodeNameError
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	c := caddy.NewTestController("dns", "forward . "+silent.LocalAddr().String()+" "+s.Addr)
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f := fs[0]
+	for _, p := range f.proxies {
+		p.SetReadTimeout(100 * time.Millisecond)
+	}
+	o := &observingPolicy{Policy: &sequential{}}
+	f.p = o
+	f.OnStartup()
+	defer f.OnShutdown()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	f.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), m)
+
+	if len(o.results) != 2 {
+		t.Fatalf("Expected 2 results, got %+v", o.results)
+	}
+	if x := o.results[0]; x.addr != silent.LocalAddr().String() || x.rcode != dns.RcodeServerFailure || x.err == nil {
+		t.Errorf("Expected a failed attempt to %s, got %+v", silent.LocalAddr(), x)
+	}
+	if x := o.results[1]; x.addr != s.Addr || x.rcode != dns.RcodeNameError || x.err != nil {
+		t.Errorf("Expected NXDOMAIN from %s, got %+v", s.Addr, x)
+	}
+}
@@ -21,4 +21,28 @@ var (
 		Name:      "max_concurrent_rejects_total",
 		Help:      "Counter of the number of queries rejected because the concurrent queries were at maximum.",
 	})
+	LatencyExploreCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "policy_latency_explorations_total",
+		Help:      "Counter of the number of times the latency policy listed an upstream first to explore it.",
+	}, []string{"to"})
+	LatencyExploreRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "policy_latency_explore_ratio",
+		Help:      "Gauge of the configured fraction of queries the latency policy uses to explore upstreams, per zone.",
+	}, []string{"from"})
+	LatencyEWMA = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "policy_latency_ewma_seconds",
+		Help:      "Gauge of the EWMA of the round-trip time the latency policies keep per upstream.",
+	}, []string{"to"})
+	LatencyFirstCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "forward",
+		Name:      "policy_latency_first_total",
+		Help:      "Counter of the number of times the latency policies listed an upstream first.",
+	}, []string{"to"})
 )
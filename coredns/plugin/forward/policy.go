@@ -1,15 +1,17 @@
 package forward
 
 import (
+	"errors"
+	"math"
+	"net"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/VividCortex/ewma"
+	pkglatency "github.com/coredns/coredns/plugin/pkg/latency"
 	"github.com/coredns/coredns/plugin/pkg/proxy"
 	"github.com/coredns/coredns/plugin/pkg/rand"
-	"github.com/miekg/dns"
 )
 
 // Policy defines a policy we use for selecting upstreams.
@@ -18,6 +20,14 @@ type Policy interface {
 	String() string
 }
 
+// Observer is an optional interface for policies that learn from the outcome of each attempt to
+// an upstream. It is called after every attempt, successful or not, with the round-trip time of
+// that attempt alone. rcode is the rcode of the reply, or SERVFAIL when there is none, in which
+// case err tells what went wrong.
+type Observer interface {
+	OnResult(p *proxy.Proxy, rtt time.Duration, rcode int, err error)
+}
+
 // random is a policy that implements random upstream selection.
 type random struct{}
 
@@ -70,60 +80,267 @@ func (r *sequential) List(p []*proxy.Proxy) []*proxy.Proxy {
 	return p
 }
 
-// latency is a load-balancing policy
-// that selects a proxy based on the latency of recent requests.
+// latency is a load-balancing policy that selects a proxy based on the latency of recent
+// requests, as estimated by pkglatency.Estimator.
 type latency struct {
-	// latencyStats stores the Exponentially Weighted Moving Average (EWMA)
-	// of request latencies for each proxy, indexed by the proxy's address.
-	latencyStats map[string]ewma.MovingAverage
-	mux          sync.Mutex
+	*pkglatency.Estimator
+}
+
+func newLatency() *latency {
+	return &latency{Estimator: pkglatency.New(isTimeout)}
 }
 
 func (r *latency) String() string { return "latency" }
 
-// List function sorts the list of proxies based on their EWMA latency.
-// Proxies with lower latency (faster response time) are prioritized.
+// List function sorts the list of proxies based on their EWMA latency plus any failure penalty,
+// in the order pkglatency.Estimator.Order returns. Proxies with lower latency (faster response time)
+// are prioritized. Proxies that are still warming up are ranked at the median latency of the others,
+// so a brand-new proxy sorts neither first nor last.
 func (r *latency) List(p []*proxy.Proxy) []*proxy.Proxy {
-	r.mux.Lock()
-	defer r.mux.Unlock()
+	r.exportEWMA(p)
+	order, explored := r.Order(proxyAddrs(p))
+	proxies := make([]*proxy.Proxy, len(p))
+	for i, j := range order {
+		proxies[i] = p[j]
+	}
+	if explored {
+		LatencyExploreCount.WithLabelValues(proxies[0].Addr()).Add(1)
+	}
+	if len(proxies) > 0 {
+		LatencyFirstCount.WithLabelValues(proxies[0].Addr()).Add(1)
+	}
+	return proxies
+}
+
+// OnResult implements Observer, it updates the EWMA latency stats for a proxy once an attempt is complete.
+func (r *latency) OnResult(p *proxy.Proxy, rtt time.Duration, rcode int, err error) {
+	if ewma, warm := r.Observe(p.Addr(), rtt, rcode, err); warm {
+		LatencyEWMA.WithLabelValues(p.Addr()).Set(ewma.Seconds())
+	}
+}
+
+// exportEWMA sets the EWMA gauge of the proxies to their current estimate, so a proxy that isn't
+// sampled anymore shows the EWMA it decayed to, not the one of its last sample.
+func (r *latency) exportEWMA(p []*proxy.Proxy) {
+	for addr, ewma := range r.Estimates(proxyAddrs(p)) {
+		LatencyEWMA.WithLabelValues(addr).Set(ewma.Seconds())
+	}
+}
+
+// isTimeout returns true if err means the attempt timed out, as opposed to failing outright.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// weightedLatency is a policy that orders the proxies at random, giving faster proxies a better chance
+// to be listed first. It keeps the same latency stats as the latency policy. By default a proxy's chance
+// is proportional to the inverse of its latency, with a temperature it is a softmax of its latency.
+type weightedLatency struct {
+	*latency
+	// temperature is the latency difference that makes a proxy e times less likely to be picked.
+	temperature time.Duration
+}
+
+// minWeightedLatency is the latency below which proxies stop getting more likely to be picked.
+const minWeightedLatency = time.Millisecond
+
+func newWeightedLatency() *weightedLatency {
+	return &weightedLatency{latency: newLatency()}
+}
+
+func (r *weightedLatency) String() string { return "weighted_latency" }
+
+// List orders the proxies with a weighted random shuffle, based on their latency.
+func (r *weightedLatency) List(p []*proxy.Proxy) []*proxy.Proxy {
+	r.exportEWMA(p)
+	values := r.Values(proxyAddrs(p))
+
+	lowest := math.Inf(1)
+	for _, v := range values {
+		lowest = math.Min(lowest, v)
+	}
+
+	// Efraimidis-Spirakis: sorting on u^(1/weight), with u uniform in [0,1), is a weighted shuffle.
+	keys := make(map[string]float64, len(p))
+	for _, px := range p {
+		keys[px.Addr()] = math.Log(rn.Float64()) / r.weight(values[px.Addr()], lowest)
+	}
 
 	proxies := make([]*proxy.Proxy, len(p))
 	copy(proxies, p)
-
-	// sort the proxies based on their latency.
 	sort.Slice(proxies, func(i, j int) bool {
-		currentProxyEWMA, currentProxyExists := r.latencyStats[proxies[i].Addr()]
-		nextProxyEWMA, nextProxyExists := r.latencyStats[proxies[j].Addr()]
+		return keys[proxies[i].Addr()] > keys[proxies[j].Addr()]
+	})
+	if len(proxies) > 0 {
+		LatencyFirstCount.WithLabelValues(proxies[0].Addr()).Add(1)
+	}
+	return proxies
+}
 
-		// assume it has the maximum latency
-		if !currentProxyExists {
-			return false
+// weight returns the relative chance of a proxy with latency v to be picked, lowest is the
+// latency of the fastest proxy.
+func (r *weightedLatency) weight(v, lowest float64) float64 {
+	if r.temperature > 0 {
+		return math.Exp(-(v - lowest) / float64(r.temperature))
+	}
+	return 1 / math.Max(v, float64(minWeightedLatency))
+}
+
+// p2cEWMA is a policy that picks two random healthy proxies and lists first the one with the
+// lowest cost: its peak EWMA latency multiplied by its number of in-flight requests, like
+// Finagle and Linkerd do. Unlike sorting everything by latency, the fastest proxy doesn't
+// get the whole herd of queries at once.
+type p2cEWMA struct {
+	// latencyStats stores the peak EWMA of each proxy, indexed by the proxy's address.
+	latencyStats map[string]*peakEWMA
+	// decay is the time constant with which the peak EWMA moves towards lower latencies.
+	decay time.Duration
+	// maxfails is the number of failed health checks after which a proxy isn't a choice,
+	// set from the forward plugin's max_fails.
+	maxfails uint32
+	// down, inflight and now are swapped in tests.
+	down     func(p *proxy.Proxy, maxfails uint32) bool
+	inflight func(*proxy.Proxy) int64
+	now      func() time.Time
+	mux      sync.Mutex
+}
+
+// peakEWMA is an EWMA that jumps to any latency higher than its value, and decays towards lower
+// ones based on the time since the last update.
+type peakEWMA struct {
+	value float64
+	at    time.Time
+}
+
+const (
+	defaultP2CDecay = 10 * time.Second
+	// p2cPenalty is the latency assumed for each in-flight request to a proxy we have no latency for.
+	p2cPenalty = 2 * time.Second
+)
+
+func newP2CEWMA() *p2cEWMA {
+	return &p2cEWMA{
+		latencyStats: make(map[string]*peakEWMA),
+		decay:        defaultP2CDecay,
+		maxfails:     2,
+		down:         (*proxy.Proxy).Down,
+		inflight:     (*proxy.Proxy).InFlight,
+		now:          time.Now,
+	}
+}
+
+func (r *p2cEWMA) String() string { return "p2c_ewma" }
+
+// List puts the better of two random healthy proxies first, followed by the other one. The rest
+// of the proxies follow in random order.
+func (r *p2cEWMA) List(p []*proxy.Proxy) []*proxy.Proxy {
+	if len(p) < 2 {
+		return p
+	}
+
+	proxies := make([]*proxy.Proxy, len(p))
+	for i, j := range rn.Perm(len(p)) {
+		proxies[i] = p[j]
+	}
+
+	// the first two healthy proxies of the shuffled list are our two random choices.
+	first, second := -1, -1
+	for i := range proxies {
+		if r.down(proxies[i], r.maxfails) {
+			continue
 		}
-		// assume it has the maximum latency
-		if !nextProxyExists {
-			return true
+		if first < 0 {
+			first = i
+			continue
 		}
+		second = i
+		break
+	}
+	// with fewer than two healthy proxies, the empty choices are filled with any other proxy,
+	// and a healthy one stays first.
+	choices := 2
+	if second < 0 {
+		choices = 1
+		second = 1
+	}
+	if first < 0 {
+		choices = 0
+		first = 0
+	}
+	proxies[0], proxies[first] = proxies[first], proxies[0]
+	proxies[1], proxies[second] = proxies[second], proxies[1]
+	if choices == 1 {
+		return proxies
+	}
 
-		// order them based on their EWMA latency.
-		return currentProxyEWMA.Value() < nextProxyEWMA.Value()
-	})
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	now := r.now()
+	if r.cost(proxies[1], now) < r.cost(proxies[0], now) {
+		proxies[0], proxies[1] = proxies[1], proxies[0]
+	}
 	return proxies
 }
 
-// OnComplete updates the EWMA latency stats for a proxy once a request is complete.
-func (r *latency) OnComplete(proxyAddr string, rtt time.Duration, msg *dns.Msg) {
+// cost returns the peak EWMA of the proxy multiplied by its in-flight requests plus this one.
+func (r *p2cEWMA) cost(p *proxy.Proxy, now time.Time) float64 {
+	inflight := r.inflight(p)
+	stat, ok := r.latencyStats[p.Addr()]
+	if !ok {
+		// no latency known yet: try it when it is idle, but don't pile queries on top of it.
+		return float64(inflight) * float64(p2cPenalty)
+	}
+	return stat.decayed(now, r.decay) * float64(inflight+1)
+}
+
+// OnResult implements Observer, it updates the peak EWMA for a proxy once an attempt is complete.
+func (r *p2cEWMA) OnResult(p *proxy.Proxy, rtt time.Duration, rcode int, err error) {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 
-	// if we already have latency data for this proxy, retrieve it.
-	// if we don't, initialize a new EWMA.
-	ewmaVal, ok := r.latencyStats[proxyAddr]
+	stat, ok := r.latencyStats[p.Addr()]
 	if !ok {
-		ewmaVal = ewma.NewMovingAverage()
-		r.latencyStats[proxyAddr] = ewmaVal
+		stat = new(peakEWMA)
+		r.latencyStats[p.Addr()] = stat
+	}
+	stat.observe(float64(rtt), r.now(), r.decay)
+}
+
+// observe adds a latency sample taken at time now.
+func (e *peakEWMA) observe(rtt float64, now time.Time, decay time.Duration) {
+	if rtt > e.value {
+		e.value = rtt
+	} else {
+		w := e.weight(now, decay)
+		e.value = e.value*w + rtt*(1-w)
+	}
+	e.at = now
+}
+
+// decayed returns the value of the peak EWMA at time now, as if a latency of 0 was observed.
+// This lets a proxy that isn't picked anymore become attractive again over time.
+func (e *peakEWMA) decayed(now time.Time, decay time.Duration) float64 {
+	return e.value * e.weight(now, decay)
+}
+
+// weight returns how much the current value still counts at time now.
+func (e *peakEWMA) weight(now time.Time, decay time.Duration) float64 {
+	elapsed := now.Sub(e.at)
+	if elapsed <= 0 || decay <= 0 {
+		return 1
+	}
+	return math.Exp(-float64(elapsed) / float64(decay))
+}
+
+// proxyAddrs returns the addresses of the proxies, in order.
+func proxyAddrs(proxies []*proxy.Proxy) []string {
+	addrs := make([]string, len(proxies))
+	for i, p := range proxies {
+		addrs[i] = p.Addr()
 	}
-	// update the EWMA with the new round-trip time (rtt) measurement.
-	ewmaVal.Add(float64(rtt))
+	return addrs
 }
 
 var rn = rand.New(time.Now().UnixNano())
@@ -0,0 +1,384 @@
+package forward
+
+import (
+	"errors"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/proxy"
+	"github.com/coredns/coredns/plugin/pkg/transport"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testClock is a clock that only moves when told to.
+type testClock struct{ t time.Time }
+
+func newTestClock() *testClock { return &testClock{t: time.Now()} }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"deadline exceeded", os.ErrDeadlineExceeded, true},
+		{"wrapped deadline exceeded", &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}, true},
+		{"connect error", errors.New("connection refused"), false},
+	}
+
+	for _, test := range tests {
+		if x := isTimeout(test.err); x != test.expected {
+			t.Errorf("Test %q: expected timeout %v, got %v", test.name, test.expected, x)
+		}
+	}
+}
+
+func TestLatencyRecoveredUpstreamIsPreferredAgain(t *testing.T) {
+	clock := newTestClock()
+	l := newLatency()
+	l.Now = clock.now
+	l.StaleHalfLife = 30 * time.Second
+
+	peer := proxy.NewProxy("1.1.1.1:53", transport.DNS)
+	recovered := proxy.NewProxy("2.2.2.2:53", transport.DNS)
+	proxies := []*proxy.Proxy{peer, recovered}
+	l.OnResult(recovered, 500*time.Millisecond, dns.RcodeSuccess, nil)
+	l.OnResult(peer, 10*time.Millisecond, dns.RcodeSuccess, nil)
+	for i := 0; i < 60; i++ {
+		clock.advance(5 * time.Second)
+		l.OnResult(peer, 10*time.Millisecond, dns.RcodeSuccess, nil)
+	}
+
+	// The upstream now answers in 1ms, and is probed now and then, e.g. by exploring.
+	for probes := 1; probes <= 3; probes++ {
+		l.OnResult(recovered, time.Millisecond, dns.RcodeSuccess, nil)
+		if l.List(proxies)[0] == recovered {
+			return
+		}
+		clock.advance(time.Second)
+		l.OnResult(peer, 10*time.Millisecond, dns.RcodeSuccess, nil)
+	}
+	ewma, _ := l.EWMA(recovered.Addr())
+	t.Errorf("Expected %s to be listed first within 3 probes after it recovered, its EWMA is %s", recovered.Addr(), ewma)
+}
+
+func TestLatencyExplore(t *testing.T) {
+	clock := newTestClock()
+	l := newLatency()
+	l.Now = clock.now
+
+	proxies := []*proxy.Proxy{
+		proxy.NewProxy("1.1.1.1:53", transport.DNS),
+		proxy.NewProxy("2.2.2.2:53", transport.DNS),
+		proxy.NewProxy("3.3.3.3:53", transport.DNS),
+	}
+	for i, rtt := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond} {
+		l.OnResult(proxies[i], rtt, dns.RcodeSuccess, nil)
+		clock.advance(time.Second)
+	}
+	// 3.3.3.3 was sampled last, 2.2.2.2 is the least recently sampled after the fastest one.
+	l.OnResult(proxies[2], 30*time.Millisecond, dns.RcodeSuccess, nil)
+
+	tests := []struct {
+		explore  float64
+		expected []string
+	}{
+		{0, []string{"1.1.1.1:53", "2.2.2.2:53", "3.3.3.3:53"}},
+		{1, []string{"2.2.2.2:53", "1.1.1.1:53", "3.3.3.3:53"}},
+	}
+	for i, test := range tests {
+		l.Explore = test.explore
+		if x := proxyAddrs(l.List(proxies)); strings.Join(x, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, x)
+		}
+	}
+}
+
+// TestLatencyExploreFindsFasterUpstream mimics the tester changing upstream latencies at random:
+// an upstream that was slow gets fast, which strict ordering would never notice.
+func TestLatencyExploreFindsFasterUpstream(t *testing.T) {
+	l := newLatency()
+	l.StaleHalfLife = 0
+	l.Explore = 0.1
+
+	a := proxy.NewProxy("1.1.1.1:53", transport.DNS)
+	b := proxy.NewProxy("2.2.2.2:53", transport.DNS)
+	proxies := []*proxy.Proxy{a, b}
+	rtts := map[*proxy.Proxy]time.Duration{a: 50 * time.Millisecond, b: 500 * time.Millisecond}
+	l.OnResult(a, rtts[a], dns.RcodeSuccess, nil)
+	l.OnResult(b, rtts[b], dns.RcodeSuccess, nil)
+
+	rtts[b] = 5 * time.Millisecond
+	for i := 0; i < 500; i++ {
+		first := l.List(proxies)[0]
+		l.OnResult(first, rtts[first], dns.RcodeSuccess, nil)
+	}
+
+	l.Explore = 0
+	if x := l.List(proxies)[0]; x != b {
+		t.Errorf("Expected %s to be listed first after it got faster, got %s", b.Addr(), x.Addr())
+	}
+}
+
+func TestWeightedLatencyWeight(t *testing.T) {
+	tests := []struct {
+		temperature time.Duration
+		v           time.Duration
+		expected    float64
+	}{
+		{0, 10 * time.Millisecond, 1 / float64(10*time.Millisecond)},
+		{0, 0, 1 / float64(minWeightedLatency)},
+		{10 * time.Millisecond, 10 * time.Millisecond, 1},
+		{10 * time.Millisecond, 20 * time.Millisecond, math.Exp(-1)},
+	}
+	for i, test := range tests {
+		r := newWeightedLatency()
+		r.temperature = test.temperature
+		if x := r.weight(float64(test.v), float64(10*time.Millisecond)); math.Abs(x-test.expected) > 1e-12 {
+			t.Errorf("Test %d: expected weight %v, got %v", i, test.expected, x)
+		}
+	}
+}
+
+func TestWeightedLatencyList(t *testing.T) {
+	proxies := []*proxy.Proxy{
+		proxy.NewProxy("1.1.1.1:53", transport.DNS),
+		proxy.NewProxy("2.2.2.2:53", transport.DNS),
+		proxy.NewProxy("3.3.3.3:53", transport.DNS),
+	}
+	rtts := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+
+	tests := []struct {
+		name        string
+		temperature time.Duration
+		expected    []float64 // share of lists each proxy comes first in.
+	}{
+		{"inverse latency", 0, []float64{4.0 / 7, 2.0 / 7, 1.0 / 7}},
+		{"softmax", 10 * time.Millisecond, []float64{
+			1 / (1 + math.Exp(-1) + math.Exp(-3)),
+			math.Exp(-1) / (1 + math.Exp(-1) + math.Exp(-3)),
+			math.Exp(-3) / (1 + math.Exp(-1) + math.Exp(-3)),
+		}},
+	}
+
+	const lists = 20000
+	for _, test := range tests {
+		r := newWeightedLatency()
+		r.temperature = test.temperature
+		// a clock that doesn't move keeps the EWMAs from decaying towards each other.
+		r.Now = newTestClock().now
+		for i, p := range proxies {
+			r.OnResult(p, rtts[i], dns.RcodeSuccess, nil)
+		}
+
+		first := make(map[string]int)
+		for i := 0; i < lists; i++ {
+			list := r.List(proxies)
+			if len(list) != len(proxies) {
+				t.Fatalf("Test %q: expected %d proxies, got %d", test.name, len(proxies), len(list))
+			}
+			first[list[0].Addr()]++
+		}
+		for i, p := range proxies {
+			share := float64(first[p.Addr()]) / lists
+			if math.Abs(share-test.expected[i]) > 0.02 {
+				t.Errorf("Test %q: expected %s first in %.3f of the lists, got %.3f", test.name, p.Addr(), test.expected[i], share)
+			}
+		}
+	}
+}
+
+func TestP2CEWMAList(t *testing.T) {
+	a := proxy.NewProxy("1.1.1.1:53", transport.DNS)
+	b := proxy.NewProxy("2.2.2.2:53", transport.DNS)
+
+	tests := []struct {
+		name     string
+		rtts     map[*proxy.Proxy]time.Duration // no entry means no latency known yet.
+		inflight map[*proxy.Proxy]int64
+		expected *proxy.Proxy
+	}{
+		{
+			name:     "lower latency wins",
+			rtts:     map[*proxy.Proxy]time.Duration{a: 10 * time.Millisecond, b: 50 * time.Millisecond},
+			expected: a,
+		},
+		{
+			name:     "busy proxy loses",
+			rtts:     map[*proxy.Proxy]time.Duration{a: 10 * time.Millisecond, b: 50 * time.Millisecond},
+			inflight: map[*proxy.Proxy]int64{a: 9},
+			expected: b,
+		},
+		{
+			name:     "both busy",
+			rtts:     map[*proxy.Proxy]time.Duration{a: 10 * time.Millisecond, b: 40 * time.Millisecond},
+			inflight: map[*proxy.Proxy]int64{a: 9, b: 1},
+			expected: b,
+		},
+		{
+			name:     "idle unknown proxy is tried",
+			rtts:     map[*proxy.Proxy]time.Duration{b: 10 * time.Millisecond},
+			expected: a,
+		},
+		{
+			name:     "busy unknown proxy is avoided",
+			rtts:     map[*proxy.Proxy]time.Duration{b: 10 * time.Millisecond},
+			inflight: map[*proxy.Proxy]int64{a: 1, b: 3},
+			expected: b,
+		},
+	}
+
+	for _, test := range tests {
+		clock := newTestClock()
+		r := newP2CEWMA()
+		r.now = clock.now
+		r.inflight = func(p *proxy.Proxy) int64 { return test.inflight[p] }
+		for p, rtt := range test.rtts {
+			r.OnResult(p, rtt, dns.RcodeSuccess, nil)
+		}
+
+		// the two random choices come in random order, the outcome must not depend on it.
+		for i := 0; i < 10; i++ {
+			list := r.List([]*proxy.Proxy{a, b})
+			if len(list) != 2 {
+				t.Fatalf("Test %q: expected 2 proxies, got %d", test.name, len(list))
+			}
+			if list[0] != test.expected {
+				t.Errorf("Test %q: expected %s first, got %s", test.name, test.expected.Addr(), list[0].Addr())
+				break
+			}
+		}
+	}
+}
+
+func TestP2CEWMAListAllProxies(t *testing.T) {
+	proxies := []*proxy.Proxy{
+		proxy.NewProxy("1.1.1.1:53", transport.DNS),
+		proxy.NewProxy("2.2.2.2:53", transport.DNS),
+		proxy.NewProxy("3.3.3.3:53", transport.DNS),
+		proxy.NewProxy("4.4.4.4:53", transport.DNS),
+	}
+	r := newP2CEWMA()
+
+	list := r.List(proxies)
+	seen := make(map[string]bool)
+	for _, p := range list {
+		seen[p.Addr()] = true
+	}
+	if len(list) != len(proxies) || len(seen) != len(proxies) {
+		t.Errorf("Expected every proxy to be listed once, got %v", proxyAddrs(list))
+	}
+}
+
+func TestP2CEWMAListOneHealthyProxy(t *testing.T) {
+	proxies := []*proxy.Proxy{
+		proxy.NewProxy("1.1.1.1:53", transport.DNS),
+		proxy.NewProxy("2.2.2.2:53", transport.DNS),
+		proxy.NewProxy("3.3.3.3:53", transport.DNS),
+	}
+	healthy := proxies[1]
+	r := newP2CEWMA()
+	r.down = func(p *proxy.Proxy, maxfails uint32) bool { return p != healthy }
+	// the unhealthy proxies look faster, the healthy one must still come first.
+	for _, p := range proxies {
+		if p != healthy {
+			r.OnResult(p, time.Millisecond, dns.RcodeSuccess, nil)
+		}
+	}
+	r.OnResult(healthy, 50*time.Millisecond, dns.RcodeSuccess, nil)
+
+	// the shuffle puts the healthy proxy anywhere, it must always be listed first.
+	for i := 0; i < 50; i++ {
+		list := r.List(proxies)
+		if len(list) != len(proxies) {
+			t.Fatalf("Expected %d proxies, got %d", len(proxies), len(list))
+		}
+		if list[0] != healthy {
+			t.Fatalf("Expected %s first, got %v", healthy.Addr(), proxyAddrs(list))
+		}
+	}
+}
+
+func TestPeakEWMA(t *testing.T) {
+	const decay = 10 * time.Second
+	start := time.Now()
+
+	tests := []struct {
+		name     string
+		rtt      time.Duration
+		elapsed  time.Duration
+		expected float64
+	}{
+		{"first sample", 10 * time.Millisecond, 0, float64(10 * time.Millisecond)},
+		{"peak is taken at once", 50 * time.Millisecond, time.Second, float64(50 * time.Millisecond)},
+		{"lower sample without elapsed time", 10 * time.Millisecond, 0, float64(50 * time.Millisecond)},
+		{"lower sample after one decay", 10 * time.Millisecond, decay,
+			float64(50*time.Millisecond)*math.Exp(-1) + float64(10*time.Millisecond)*(1-math.Exp(-1))},
+	}
+
+	e := new(peakEWMA)
+	now := start
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		e.observe(float64(test.rtt), now, decay)
+		if math.Abs(e.value-test.expected) > 1 {
+			t.Errorf("Test %q: expected %v, got %v", test.name, time.Duration(test.expected), time.Duration(e.value))
+		}
+	}
+
+	if x, expected := e.decayed(now.Add(decay), decay), e.value*math.Exp(-1); math.Abs(x-expected) > 1 {
+		t.Errorf("Expected an idle proxy's cost to decay to %v, got %v", time.Duration(expected), time.Duration(x))
+	}
+}
+
+func TestLatencyMetrics(t *testing.T) {
+	clock := newTestClock()
+	l := newLatency()
+	l.Now = clock.now
+	l.Warmup = 2
+
+	fast := proxy.NewProxy("10.0.0.1:53", transport.DNS)
+	slow := proxy.NewProxy("10.0.0.2:53", transport.DNS)
+	// the gauges are global, don't let another run see the values of this one.
+	t.Cleanup(func() {
+		for _, p := range []*proxy.Proxy{fast, slow} {
+			LatencyEWMA.DeleteLabelValues(p.Addr())
+			LatencyFirstCount.DeleteLabelValues(p.Addr())
+		}
+	})
+
+	l.OnResult(fast, 10*time.Millisecond, dns.RcodeSuccess, nil)
+	if x := testutil.ToFloat64(LatencyEWMA.WithLabelValues(fast.Addr())); x != 0 {
+		t.Errorf("Expected no EWMA to be exported while warming up, got %v", x)
+	}
+	l.OnResult(fast, 30*time.Millisecond, dns.RcodeSuccess, nil)
+	if x := testutil.ToFloat64(LatencyEWMA.WithLabelValues(fast.Addr())); x != 0.02 {
+		t.Errorf("Expected EWMA of 0.02s, got %v", x)
+	}
+
+	clock.advance(time.Second)
+	l.OnResult(slow, 100*time.Millisecond, dns.RcodeSuccess, nil)
+	l.OnResult(slow, 100*time.Millisecond, dns.RcodeSuccess, nil)
+	first := testutil.ToFloat64(LatencyFirstCount.WithLabelValues(fast.Addr()))
+	l.List([]*proxy.Proxy{slow, fast})
+	if x := testutil.ToFloat64(LatencyFirstCount.WithLabelValues(fast.Addr())) - first; x != 1 {
+		t.Errorf("Expected %s to be counted once as listed first, got %v", fast.Addr(), x)
+	}
+
+	// fast isn't sampled anymore, so its EWMA decays towards slow's, and that is what's exported.
+	clock.advance(l.StaleHalfLife)
+	l.List([]*proxy.Proxy{slow, fast})
+	expected := l.Estimates([]string{fast.Addr()})[fast.Addr()].Seconds()
+	if x := testutil.ToFloat64(LatencyEWMA.WithLabelValues(fast.Addr())); x != expected || x <= 0.02 {
+		t.Errorf("Expected the decayed EWMA of %vs to be exported, got %v", expected, x)
+	}
+}
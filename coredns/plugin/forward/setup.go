@@ -7,11 +7,11 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/VividCortex/ewma"
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/dnstap"
+	pkglatency "github.com/coredns/coredns/plugin/pkg/latency"
 	"github.com/coredns/coredns/plugin/pkg/parse"
 	"github.com/coredns/coredns/plugin/pkg/proxy"
 	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
@@ -66,11 +66,15 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
-// OnStartup starts a goroutines for all proxies.
+// OnStartup starts a goroutines for all proxies, and exports the configuration of the policy.
 func (f *Forward) OnStartup() (err error) {
 	for _, p := range f.proxies {
 		p.Start(f.hcInterval)
 	}
+	// the series isn't deleted on shutdown: on a reload the new instance starts first.
+	if l, ok := f.p.(*latency); ok {
+		LatencyExploreRatio.WithLabelValues(f.from).Set(l.Explore)
+	}
 	return nil
 }
 
@@ -144,6 +148,11 @@ func parseStanza(c *caddy.Controller) (*Forward, error) {
 		f.tlsConfig.ServerName = f.tlsServerName
 	}
 
+	// max_fails may come after the policy, so it's handed to the policy once the block is parsed.
+	if p, ok := f.p.(*p2cEWMA); ok {
+		p.maxfails = f.maxfails
+	}
+
 	// Initialize ClientSessionCache in tls.Config. This may speed up a TLS handshake
 	// in upcoming connections to the same TLS server.
 	f.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(len(f.proxies))
@@ -266,7 +275,23 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 		case "sequential":
 			f.p = &sequential{}
 		case "latency":
-			f.p = &latency{latencyStats: make(map[string]ewma.MovingAverage)}
+			l := newLatency()
+			if err := parseLatency(c, l); err != nil {
+				return err
+			}
+			f.p = l
+		case "weighted_latency":
+			w := newWeightedLatency()
+			if err := parseWeightedLatency(c, w); err != nil {
+				return err
+			}
+			f.p = w
+		case "p2c_ewma":
+			p := newP2CEWMA()
+			if err := parseP2CEWMA(c, p); err != nil {
+				return err
+			}
+			f.p = p
 		default:
 			return c.Errf("unknown policy '%s'", x)
 		}
@@ -291,4 +316,43 @@ func parseBlock(c *caddy.Controller, f *Forward) error {
 	return nil
 }
 
+// parseLatency parses the optional block that may follow "policy latency".
+func parseLatency(c *caddy.Controller, l *latency) error {
+	return pkglatency.ParseBlock(c, func() error {
+		return l.ParseProperty(c, "latency")
+	})
+}
+
+// parseWeightedLatency parses the optional block that may follow "policy weighted_latency".
+func parseWeightedLatency(c *caddy.Controller, w *weightedLatency) error {
+	return pkglatency.ParseBlock(c, func() (err error) {
+		switch c.Val() {
+		case "temperature":
+			w.temperature, err = pkglatency.ParseDurationArg(c)
+		case "explore":
+			// the weighted shuffle explores by itself.
+			return c.Errf("unknown weighted_latency policy property '%s'", c.Val())
+		default:
+			return w.ParseProperty(c, "weighted_latency")
+		}
+		return err
+	})
+}
+
+// parseP2CEWMA parses the optional block that may follow "policy p2c_ewma".
+func parseP2CEWMA(c *caddy.Controller, p *p2cEWMA) error {
+	return pkglatency.ParseBlock(c, func() (err error) {
+		switch c.Val() {
+		case "decay":
+			p.decay, err = pkglatency.ParseDurationArg(c)
+			if err == nil && p.decay == 0 {
+				err = errors.New("decay can't be zero")
+			}
+		default:
+			return c.Errf("unknown p2c_ewma policy property '%s'", c.Val())
+		}
+		return err
+	})
+}
+
 const max = 15 // Maximum number of upstreams.
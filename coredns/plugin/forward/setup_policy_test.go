@@ -3,8 +3,12 @@ package forward
 import (
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/VividCortex/ewma"
 	"github.com/coredns/caddy"
+	pkglatency "github.com/coredns/coredns/plugin/pkg/latency"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestSetupPolicy(t *testing.T) {
@@ -18,8 +22,33 @@ func TestSetupPolicy(t *testing.T) {
 		{"forward . 127.0.0.1 {\npolicy random\n}\n", false, "random", ""},
 		{"forward . 127.0.0.1 {\npolicy round_robin\n}\n", false, "round_robin", ""},
 		{"forward . 127.0.0.1 {\npolicy sequential\n}\n", false, "sequential", ""},
+		{"forward . 127.0.0.1 {\npolicy latency\n}\n", false, "latency", ""},
+		{"forward . 127.0.0.1 {\npolicy latency {\ntimeout_penalty 1s\nrcode_penalty 0s\n}\n}\n", false, "latency", ""},
+		{"forward . 127.0.0.1 {\npolicy latency {\nstale_half_life 0s\n}\n}\n", false, "latency", ""},
+		{"forward . 127.0.0.1 {\npolicy weighted_latency\n}\n", false, "weighted_latency", ""},
+		{"forward . 127.0.0.1 {\npolicy weighted_latency {\ntemperature 20ms\nwarmup 3\n}\n}\n", false, "weighted_latency", ""},
+		{"forward . 127.0.0.1 {\npolicy p2c_ewma\n}\n", false, "p2c_ewma", ""},
+		{"forward . 127.0.0.1 {\npolicy p2c_ewma {\ndecay 5s\n}\n}\n", false, "p2c_ewma", ""},
 		// negative
 		{"forward . 127.0.0.1 {\npolicy random2\n}\n", true, "random", "unknown policy"},
+		{"forward . 127.0.0.1 {\npolicy latency foo\n}\n", true, "latency", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nfoo 1s\n}\n}\n", true, "latency", "unknown latency policy property"},
+		{"forward . 127.0.0.1 {\npolicy latency {\ntimeout_penalty\n}\n}\n", true, "latency", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nerror_penalty -1s\n}\n}\n", true, "latency", "can't be negative"},
+		{"forward . 127.0.0.1 {\npolicy latency {\npenalty_half_life 1s 2s\n}\n}\n", true, "latency", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npolicy latency {\ndecay 0.5\n}\n}\n", true, "latency", "decay can't be less than 1"},
+		{"forward . 127.0.0.1 {\npolicy latency {\ndecay fast\n}\n}\n", true, "latency", "invalid syntax"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nwarmup -1\n}\n}\n", true, "latency", "warmup can't be negative"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nwarmup\n}\n}\n", true, "latency", "Wrong argument count"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nstale_half_life -1m\n}\n}\n", true, "latency", "can't be negative"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nexplore 101%\n}\n}\n", true, "latency", "explore must be between"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nexplore -5%\n}\n}\n", true, "latency", "explore must be between"},
+		{"forward . 127.0.0.1 {\npolicy latency {\nexplore some\n}\n}\n", true, "latency", "invalid syntax"},
+		{"forward . 127.0.0.1 {\npolicy weighted_latency {\ntemperature -1ms\n}\n}\n", true, "weighted_latency", "can't be negative"},
+		{"forward . 127.0.0.1 {\npolicy weighted_latency {\nexplore 5%\n}\n}\n", true, "weighted_latency", "unknown weighted_latency policy property"},
+		{"forward . 127.0.0.1 {\npolicy weighted_latency {\nfoo\n}\n}\n", true, "weighted_latency", "unknown weighted_latency policy property"},
+		{"forward . 127.0.0.1 {\npolicy p2c_ewma {\ndecay 0s\n}\n}\n", true, "p2c_ewma", "decay can't be zero"},
+		{"forward . 127.0.0.1 {\npolicy p2c_ewma {\nexplore 5%\n}\n}\n", true, "p2c_ewma", "unknown p2c_ewma policy property"},
 	}
 
 	for i, test := range tests {
@@ -45,3 +74,143 @@ func TestSetupPolicy(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupLatencyPenalties(t *testing.T) {
+	input := `forward . 127.0.0.1 {
+	policy latency {
+		timeout_penalty 3s
+		rcode_penalty 200ms
+		error_penalty 1s
+		penalty_half_life 30s
+		stale_half_life 1m
+	}
+	max_fails 3
+}`
+	c := caddy.NewTestController("dns", input)
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	l, ok := fs[0].p.(*latency)
+	if !ok {
+		t.Fatalf("Expected latency policy, got: %s", fs[0].p.String())
+	}
+
+	expected := pkglatency.Penalties{Timeout: 3 * time.Second, Rcode: 200 * time.Millisecond, Err: time.Second, HalfLife: 30 * time.Second}
+	if l.Penalties != expected {
+		t.Errorf("Expected penalties %+v, got %+v", expected, l.Penalties)
+	}
+	if l.StaleHalfLife != time.Minute {
+		t.Errorf("Expected stale half-life %s, got %s", time.Minute, l.StaleHalfLife)
+	}
+	// the rest of the forward block is still parsed.
+	if fs[0].maxfails != 3 {
+		t.Errorf("Expected max_fails 3, got %d", fs[0].maxfails)
+	}
+}
+
+func TestSetupP2CEWMAMaxFails(t *testing.T) {
+	// max_fails comes after the policy, and still reaches it.
+	input := `forward . 127.0.0.1 {
+	policy p2c_ewma
+	max_fails 5
+}`
+	c := caddy.NewTestController("dns", input)
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	p, ok := fs[0].p.(*p2cEWMA)
+	if !ok {
+		t.Fatalf("Expected p2c_ewma policy, got: %s", fs[0].p.String())
+	}
+	if p.maxfails != 5 {
+		t.Errorf("Expected the policy to get max_fails 5, got %d", p.maxfails)
+	}
+}
+
+func TestSetupLatencyDecayWarmup(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedDecay  float64
+		expectedWarmup int
+	}{
+		{"forward . 127.0.0.1 {\npolicy latency\n}\n", ewma.AVG_METRIC_AGE, 0},
+		{"forward . 127.0.0.1 {\npolicy latency {\ndecay 10\n}\n}\n", 10, 0},
+		{"forward . 127.0.0.1 {\npolicy latency {\nwarmup 5\n}\n}\n", ewma.AVG_METRIC_AGE, 5},
+		{"forward . 127.0.0.1 {\npolicy latency {\ndecay 2.5\nwarmup 3\n}\n}\n", 2.5, 3},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		fs, err := parseForward(c)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got: %v", i, err)
+		}
+		l := fs[0].p.(*latency)
+		if l.Decay != test.expectedDecay {
+			t.Errorf("Test %d: expected decay %v, got %v", i, test.expectedDecay, l.Decay)
+		}
+		if l.Warmup != test.expectedWarmup {
+			t.Errorf("Test %d: expected warmup %d, got %d", i, test.expectedWarmup, l.Warmup)
+		}
+	}
+}
+
+func TestSetupLatencyExplore(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedExplore float64
+	}{
+		{"forward . 127.0.0.1 {\npolicy latency\n}\n", 0},
+		{"forward . 127.0.0.1 {\npolicy latency {\nexplore 5%\n}\n}\n", 0.05},
+		{"forward . 127.0.0.1 {\npolicy latency {\nexplore 50\n}\n}\n", 0.5},
+		{"forward . 127.0.0.1 {\npolicy latency {\nexplore 100%\n}\n}\n", 1},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		fs, err := parseForward(c)
+		if err != nil {
+			t.Fatalf("Test %d: expected no error, got: %v", i, err)
+		}
+		if x := fs[0].p.(*latency).Explore; x != test.expectedExplore {
+			t.Errorf("Test %d: expected explore %v, got %v", i, test.expectedExplore, x)
+		}
+	}
+}
+
+func TestLatencyExploreRatioPerZone(t *testing.T) {
+	c := caddy.NewTestController("dns", "forward example.org 127.0.0.1 {\npolicy latency {\nexplore 5%\n}\n}\nforward example.net 127.0.0.1 {\npolicy latency\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	for _, f := range fs {
+		f.OnStartup()
+		defer f.OnShutdown()
+	}
+	for zone, expected := range map[string]float64{"example.org.": 0.05, "example.net.": 0} {
+		if x := testutil.ToFloat64(LatencyExploreRatio.WithLabelValues(zone)); x != expected {
+			t.Errorf("Expected explore ratio %v for %s, got %v", expected, zone, x)
+		}
+	}
+}
+
+func TestSetupWeightedLatency(t *testing.T) {
+	c := caddy.NewTestController("dns", "forward . 127.0.0.1 {\npolicy weighted_latency {\ntemperature 20ms\nwarmup 3\n}\n}\n")
+	fs, err := parseForward(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	w, ok := fs[0].p.(*weightedLatency)
+	if !ok {
+		t.Fatalf("Expected weighted_latency policy, got: %s", fs[0].p.String())
+	}
+	if w.temperature != 20*time.Millisecond {
+		t.Errorf("Expected temperature %s, got %s", 20*time.Millisecond, w.temperature)
+	}
+	if w.Warmup != 3 {
+		t.Errorf("Expected warmup 3, got %d", w.Warmup)
+	}
+}
@@ -64,7 +64,15 @@ func (g *GRPC) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 			ctx = ot.ContextWithSpan(ctx, child)
 		}
 
+		start := time.Now()
 		ret, err = proxy.query(ctx, r)
+		if o, ok := g.p.(Observer); ok {
+			rcode := dns.RcodeServerFailure
+			if ret != nil {
+				rcode = ret.Rcode
+			}
+			o.OnResult(proxy, time.Since(start), rcode, err)
+		}
 		if err != nil {
 			// Continue with the next proxy
 			continue
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/coredns/coredns/pb"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
@@ -73,3 +74,83 @@ func TestGRPC(t *testing.T) {
 		})
 	}
 }
+
+// observingPolicy is a sequential policy that records the results it is told about.
+type observingPolicy struct {
+	sequential
+	results []observedResult
+}
+
+type observedResult struct {
+	addr  string
+	rcode int
+	err   error
+}
+
+func (o *observingPolicy) OnResult(p *Proxy, rtt time.Duration, rcode int, err error) {
+	o.results = append(o.results, observedResult{p.addr, rcode, err})
+}
+
+func TestObserverOnResult(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	reply := new(dns.Msg)
+	reply.SetRcode(m, dns.RcodeNameError)
+	msg, err := reply.Pack()
+	if err != nil {
+		t.Fatalf("Error packing response: %s", err.Error())
+	}
+
+	o := &observingPolicy{}
+	g := newGRPC()
+	g.from = "."
+	g.p = o
+	g.proxies = []*Proxy{
+		{addr: "ko", client: &testServiceClient{dnsPacket: nil, err: errors.New("")}},
+		{addr: "ok", client: &testServiceClient{dnsPacket: &pb.DnsPacket{Msg: msg}, err: nil}},
+	}
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := g.ServeDNS(context.TODO(), rec, m); err != nil {
+		t.Fatalf("Expected to receive reply, but got: %s", err)
+	}
+
+	if len(o.results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(o.results))
+	}
+	if x := o.results[0]; x.addr != "ko" || x.rcode != dns.RcodeServerFailure || x.err == nil {
+		t.Errorf("Expected a failed query to ko, got %+v", x)
+	}
+	if x := o.results[1]; x.addr != "ok" || x.rcode != dns.RcodeNameError || x.err != nil {
+		t.Errorf("Expected NXDOMAIN from ok, got %+v", x)
+	}
+}
+
+func TestLatencyFailingUpstreamDropsToEnd(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	msg, err := reply.Pack()
+	if err != nil {
+		t.Fatalf("Error packing response: %s", err.Error())
+	}
+
+	l := newLatency()
+	g := newGRPC()
+	g.from = "."
+	g.p = l
+	ko := &Proxy{addr: "ko", client: &testServiceClient{dnsPacket: nil, err: errors.New("")}}
+	ok := &Proxy{addr: "ok", client: &testServiceClient{dnsPacket: &pb.DnsPacket{Msg: msg}, err: nil}}
+	g.proxies = []*Proxy{ko, ok}
+
+	for i := 0; i < 2; i++ {
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := g.ServeDNS(context.TODO(), rec, m); err != nil {
+			t.Fatalf("Expected to receive reply, but got: %s", err)
+		}
+	}
+
+	if x := l.List(g.proxies)[0]; x != ok {
+		t.Errorf("Expected %s to be listed first after %s failed, got %s", ok.addr, ko.addr, x.addr)
+	}
+}
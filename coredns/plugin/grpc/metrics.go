@@ -28,4 +28,16 @@ var (
 		Buckets:   plugin.TimeBuckets,
 		Help:      "Histogram of the time each request took.",
 	}, []string{"to"})
+	LatencyExploreCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "grpc",
+		Name:      "policy_latency_explorations_total",
+		Help:      "Counter of the number of times the latency policy listed an upstream first to explore it.",
+	}, []string{"to"})
+	LatencyExploreRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "grpc",
+		Name:      "policy_latency_explore_ratio",
+		Help:      "Gauge of the configured fraction of queries the latency policy uses to explore upstreams, per zone.",
+	}, []string{"from"})
 )
@@ -1,10 +1,16 @@
 package grpc
 
 import (
+	"context"
+	"errors"
 	"sync/atomic"
 	"time"
 
+	pkglatency "github.com/coredns/coredns/plugin/pkg/latency"
 	"github.com/coredns/coredns/plugin/pkg/rand"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Policy defines a policy we use for selecting upstreams.
@@ -13,6 +19,14 @@ type Policy interface {
 	String() string
 }
 
+// Observer is an optional interface for policies that learn from the outcome of each query to
+// an upstream. It is called after every query, successful or not, with the round-trip time of
+// that query alone. rcode is the rcode of the reply, or SERVFAIL when there is none, in which
+// case err tells what went wrong.
+type Observer interface {
+	OnResult(p *Proxy, rtt time.Duration, rcode int, err error)
+}
+
 // random is a policy that implements random upstream selection.
 type random struct{}
 
@@ -65,4 +79,51 @@ func (r *sequential) List(p []*Proxy) []*Proxy {
 	return p
 }
 
+// latency is a load-balancing policy that selects a proxy based on the latency of recent
+// requests, as estimated by pkglatency.Estimator.
+type latency struct {
+	*pkglatency.Estimator
+}
+
+func newLatency() *latency {
+	return &latency{Estimator: pkglatency.New(isTimeout)}
+}
+
+func (r *latency) String() string { return "latency" }
+
+// List function sorts the list of proxies based on their EWMA latency plus any failure penalty,
+// in the order pkglatency.Estimator.Order returns. Proxies with lower latency (faster response time)
+// are prioritized. Proxies that are still warming up are ranked at the median latency of the others,
+// so a brand-new proxy sorts neither first nor last.
+func (r *latency) List(p []*Proxy) []*Proxy {
+	order, explored := r.Order(proxyAddrs(p))
+	proxies := make([]*Proxy, len(p))
+	for i, j := range order {
+		proxies[i] = p[j]
+	}
+	if explored {
+		LatencyExploreCount.WithLabelValues(proxies[0].addr).Add(1)
+	}
+	return proxies
+}
+
+// OnResult implements Observer, it updates the EWMA latency stats for a proxy once a query is complete.
+func (r *latency) OnResult(p *Proxy, rtt time.Duration, rcode int, err error) {
+	r.Observe(p.addr, rtt, rcode, err)
+}
+
+// isTimeout returns true if err means the query ran out of time, as opposed to failing outright.
+func isTimeout(err error) bool {
+	return status.Code(err) == codes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded)
+}
+
+// proxyAddrs returns the addresses of the proxies, in order.
+func proxyAddrs(proxies []*Proxy) []string {
+	addrs := make([]string, len(proxies))
+	for i, p := range proxies {
+		addrs[i] = p.addr
+	}
+	return addrs
+}
+
 var rn = rand.New(time.Now().UnixNano())
@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testClock is a clock that only moves when told to.
+type testClock struct{ t time.Time }
+
+func newTestClock() *testClock { return &testClock{t: time.Now()} }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{"context deadline", context.DeadlineExceeded, true},
+		{"unavailable", status.Error(codes.Unavailable, "connection refused"), false},
+		{"other error", errors.New("bad packet"), false},
+	}
+
+	for _, test := range tests {
+		if x := isTimeout(test.err); x != test.expected {
+			t.Errorf("Test %q: expected timeout %v, got %v", test.name, test.expected, x)
+		}
+	}
+}
+
+func TestLatencyList(t *testing.T) {
+	clock := newTestClock()
+	l := newLatency()
+	l.Now = clock.now
+	l.Penalties.HalfLife = 10 * time.Second
+
+	failing := &Proxy{addr: "1.1.1.1:53"}
+	healthy := &Proxy{addr: "2.2.2.2:53"}
+	proxies := []*Proxy{healthy, failing}
+	l.OnResult(failing, time.Millisecond, dns.RcodeSuccess, nil)
+	l.OnResult(healthy, 100*time.Millisecond, dns.RcodeSuccess, nil)
+	if x := l.List(proxies)[0]; x != failing {
+		t.Fatalf("Expected the fastest proxy %s to be listed first, got %s", failing.addr, x.addr)
+	}
+
+	l.OnResult(failing, time.Millisecond, dns.RcodeServerFailure, nil)
+	if x := l.List(proxies)[0]; x != healthy {
+		t.Fatalf("Expected %s to be listed first after a SERVFAIL, got %s", healthy.addr, x.addr)
+	}
+
+	// A couple of half-lives and the penalty no longer outweighs the latency difference.
+	clock.advance(time.Minute)
+	if x := l.List(proxies)[0]; x != failing {
+		t.Errorf("Expected %s to be listed first once its penalty decayed, got %s", failing.addr, x.addr)
+	}
+}
+
+func TestLatencyExplore(t *testing.T) {
+	clock := newTestClock()
+	l := newLatency()
+	l.Now = clock.now
+
+	proxies := []*Proxy{{addr: "1.1.1.1:53"}, {addr: "2.2.2.2:53"}, {addr: "3.3.3.3:53"}}
+	for i, rtt := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond} {
+		l.OnResult(proxies[i], rtt, dns.RcodeSuccess, nil)
+		clock.advance(time.Second)
+	}
+
+	l.Explore = 1
+	// 1.1.1.1 is the fastest, so exploring lists the least recently sampled of the others first.
+	if x := l.List(proxies)[0]; x.addr != "2.2.2.2:53" {
+		t.Errorf("Expected 2.2.2.2:53 to be explored, got %s", x.addr)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	pkglatency "github.com/coredns/coredns/plugin/pkg/latency"
 	"github.com/coredns/coredns/plugin/pkg/parse"
 	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
 )
@@ -28,6 +29,17 @@ func setup(c *caddy.Controller) error {
 		return g
 	})
 
+	c.OnStartup(g.OnStartup)
+
+	return nil
+}
+
+// OnStartup exports the configuration of the policy.
+func (g *GRPC) OnStartup() error {
+	// the series isn't deleted on shutdown: on a reload the new instance starts first.
+	if l, ok := g.p.(*latency); ok {
+		LatencyExploreRatio.WithLabelValues(g.from).Set(l.Explore)
+	}
 	return nil
 }
 
@@ -132,6 +144,12 @@ func parseBlock(c *caddy.Controller, g *GRPC) error {
 			g.p = &roundRobin{}
 		case "sequential":
 			g.p = &sequential{}
+		case "latency":
+			l := newLatency()
+			if err := parseLatency(c, l); err != nil {
+				return err
+			}
+			g.p = l
 		default:
 			return c.Errf("unknown policy '%s'", x)
 		}
@@ -144,4 +162,11 @@ func parseBlock(c *caddy.Controller, g *GRPC) error {
 	return nil
 }
 
+// parseLatency parses the optional block that may follow "policy latency".
+func parseLatency(c *caddy.Controller, l *latency) error {
+	return pkglatency.ParseBlock(c, func() error {
+		return l.ParseProperty(c, "latency")
+	})
+}
+
 const max = 15 // Maximum number of upstreams.
@@ -3,8 +3,11 @@ package grpc
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
+	pkglatency "github.com/coredns/coredns/plugin/pkg/latency"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestSetupPolicy(t *testing.T) {
@@ -18,8 +21,13 @@ func TestSetupPolicy(t *testing.T) {
 		{"grpc . 127.0.0.1 {\npolicy random\n}\n", false, "random", ""},
 		{"grpc . 127.0.0.1 {\npolicy round_robin\n}\n", false, "round_robin", ""},
 		{"grpc . 127.0.0.1 {\npolicy sequential\n}\n", false, "sequential", ""},
+		{"grpc . 127.0.0.1 {\npolicy latency\n}\n", false, "latency", ""},
+		{"grpc . 127.0.0.1 {\npolicy latency {\ntimeout_penalty 1s\n}\n}\n", false, "latency", ""},
 		// negative
 		{"grpc . 127.0.0.1 {\npolicy random2\n}\n", true, "random", "unknown policy"},
+		{"grpc . 127.0.0.1 {\npolicy latency {\nfoo 1s\n}\n}\n", true, "latency", "unknown latency policy property"},
+		{"grpc . 127.0.0.1 {\npolicy latency {\ntimeout_penalty -1s\n}\n}\n", true, "latency", "negative"},
+		{"grpc . 127.0.0.1 {\npolicy latency {\nexplore 150%\n}\n}\n", true, "latency", ""},
 	}
 
 	for i, test := range tests {
@@ -45,3 +53,59 @@ func TestSetupPolicy(t *testing.T) {
 		}
 	}
 }
+
+func TestSetupLatency(t *testing.T) {
+	input := `grpc . 127.0.0.1 {
+	policy latency {
+		timeout_penalty 3s
+		rcode_penalty 1s
+		error_penalty 4s
+		penalty_half_life 1m
+		stale_half_life 0
+		explore 5%
+		decay 10
+		warmup 3
+	}
+	tls_servername example.org
+}`
+	c := caddy.NewTestController("dns", input)
+	g, err := parseGRPC(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+
+	l, ok := g.p.(*latency)
+	if !ok {
+		t.Fatalf("Expected latency policy, got %s", g.p)
+	}
+	expected := pkglatency.Penalties{Timeout: 3 * time.Second, Rcode: time.Second, Err: 4 * time.Second, HalfLife: time.Minute}
+	if l.Penalties != expected {
+		t.Errorf("Expected penalties %+v, got %+v", expected, l.Penalties)
+	}
+	if l.StaleHalfLife != 0 {
+		t.Errorf("Expected stale half-life 0, got %s", l.StaleHalfLife)
+	}
+	if l.Explore != 0.05 {
+		t.Errorf("Expected explore 0.05, got %v", l.Explore)
+	}
+	if l.Decay != 10 || l.Warmup != 3 {
+		t.Errorf("Expected decay 10 and warmup 3, got %v and %d", l.Decay, l.Warmup)
+	}
+	if g.tlsServerName != "example.org" {
+		t.Errorf("Expected the block after the policy to be parsed, got tls_servername %q", g.tlsServerName)
+	}
+}
+
+func TestLatencyExploreRatio(t *testing.T) {
+	c := caddy.NewTestController("dns", "grpc example.org 127.0.0.1 {\npolicy latency {\nexplore 5%\n}\n}\n")
+	g, err := parseGRPC(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if err := g.OnStartup(); err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if x := testutil.ToFloat64(LatencyExploreRatio.WithLabelValues("example.org.")); x != 0.05 {
+		t.Errorf("Expected explore ratio 0.05 for example.org., got %v", x)
+	}
+}
@@ -0,0 +1,315 @@
+// Package latency keeps track of the latency of upstreams, for the latency based policies of
+// the forward and grpc plugins. Upstreams are known by their address, the plugins order their
+// own proxies with the order or the values it returns.
+package latency
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VividCortex/ewma"
+	"github.com/coredns/coredns/plugin/pkg/rand"
+
+	"github.com/miekg/dns"
+)
+
+// Estimator estimates the latency of upstreams from the outcome of the attempts made to them.
+// The configuration fields are set before it is used.
+type Estimator struct {
+	// Penalties are charged to an upstream on top of its EWMA when an attempt fails.
+	Penalties Penalties
+	// Decay is the average age, in samples, of the EWMA (see ewma.NewMovingAverage).
+	Decay float64
+	// Warmup is the number of samples averaged before an upstream's EWMA is trusted.
+	Warmup int
+	// StaleHalfLife is the time it takes for the EWMA of an upstream that isn't sampled anymore
+	// to get halfway to the median EWMA of the upstreams sampled since.
+	StaleHalfLife time.Duration
+	// Explore is the fraction of lists that start with the least recently sampled upstream.
+	Explore float64
+	// Now returns the current time, it is swapped in tests.
+	Now func() time.Time
+
+	// isTimeout tells whether an attempt failed because it timed out.
+	isTimeout func(error) bool
+	// stats stores what we know about the latency of each upstream, indexed by address.
+	stats map[string]*stat
+	mux   sync.Mutex
+}
+
+// stat holds the latency state of a single upstream.
+type stat struct {
+	// ewma is the Exponentially Weighted Moving Average (EWMA) of the measured round-trip times.
+	ewma ewma.MovingAverage
+	// samples is the number of round-trip times measured, sum is their total during warm-up.
+	samples   int
+	sum       float64
+	sampledAt time.Time
+	// penalty is the accumulated failure penalty as it was at penaltyAt, it decays from there on.
+	penalty   float64
+	penaltyAt time.Time
+}
+
+// Penalties describes the penalty model of the estimator. Each failure adds its penalty RTT to
+// the upstream, and the accumulated penalty halves every HalfLife.
+type Penalties struct {
+	Timeout  time.Duration // the attempt timed out.
+	Rcode    time.Duration // the upstream answered with SERVFAIL or REFUSED.
+	Err      time.Duration // any other error, e.g. the upstream refused the connection.
+	HalfLife time.Duration
+}
+
+// DefaultPenalties are the penalties of a new Estimator.
+var DefaultPenalties = Penalties{
+	Timeout:  2 * time.Second,
+	Rcode:    500 * time.Millisecond,
+	Err:      2 * time.Second,
+	HalfLife: 10 * time.Second,
+}
+
+const defaultStaleHalfLife = 30 * time.Second
+
+// New returns an Estimator with the default configuration. isTimeout tells whether an error
+// returned by an attempt means it timed out, as each plugin has its own errors.
+func New(isTimeout func(error) bool) *Estimator {
+	return &Estimator{
+		Penalties:     DefaultPenalties,
+		Decay:         ewma.AVG_METRIC_AGE,
+		StaleHalfLife: defaultStaleHalfLife,
+		Now:           time.Now,
+		isTimeout:     isTimeout,
+		stats:         make(map[string]*stat),
+	}
+}
+
+// Values returns the latency used to rank each of the upstreams at addrs, indexed by address:
+// their EWMA plus any failure penalty. Upstreams that are still warming up are ranked at the
+// median latency of the others, so a brand-new upstream sorts neither first nor last.
+func (e *Estimator) Values(addrs []string) map[string]float64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	now := e.Now()
+	var known []float64
+	for _, addr := range addrs {
+		if s, ok := e.stats[addr]; ok && s.warm(e.Warmup) {
+			known = append(known, e.estimate(addr, s, now))
+		}
+	}
+	prior := Median(known)
+
+	values := make(map[string]float64, len(addrs))
+	for _, addr := range addrs {
+		s, ok := e.stats[addr]
+		switch {
+		case !ok:
+			values[addr] = prior
+		case !s.warm(e.Warmup):
+			values[addr] = prior + e.penalty(s, now)
+		default:
+			values[addr] = e.estimate(addr, s, now) + e.penalty(s, now)
+		}
+	}
+	return values
+}
+
+// Order returns the order to try the upstreams at addrs in, as indexes into addrs: by the latency
+// Values ranks them at, ties keeping the order of addrs, except that now and then the one we know
+// least about goes first, see Explored. explored tells whether it did.
+func (e *Estimator) Order(addrs []string) (order []int, explored bool) {
+	values := e.Values(addrs)
+	order = make([]int, len(addrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return values[addrs[order[i]]] < values[addrs[order[j]]]
+	})
+
+	sorted := make([]string, len(order))
+	for i, j := range order {
+		sorted[i] = addrs[j]
+	}
+	i, ok := e.Explored(sorted)
+	if !ok {
+		return order, false
+	}
+	first := order[i]
+	copy(order[1:i+1], order[:i])
+	order[0] = first
+	return order, true
+}
+
+// Explored tells, for a fraction Explore of the calls, which of the upstreams at addrs to list
+// first instead of the one at addrs[0]: the one, other than addrs[0], that was sampled the longest
+// ago. Upstreams that were never sampled come before anything else. This is how we notice that an
+// upstream we don't send anything to got faster.
+func (e *Estimator) Explored(addrs []string) (int, bool) {
+	if e.Explore <= 0 || len(addrs) < 2 || rn.Float64() >= e.Explore {
+		return 0, false
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	least := 1
+	var leastAt time.Time
+	for i := 1; i < len(addrs); i++ {
+		s, ok := e.stats[addrs[i]]
+		if !ok {
+			return i, true
+		}
+		if i == 1 || s.sampledAt.Before(leastAt) {
+			least, leastAt = i, s.sampledAt
+		}
+	}
+	return least, true
+}
+
+// Observe updates the EWMA of the upstream at addr once an attempt is complete. rcode is the rcode
+// of the reply, or SERVFAIL when there is none, in which case err tells what went wrong. It returns
+// the new EWMA, and whether the upstream is warm, i.e. whether the EWMA is used.
+func (e *Estimator) Observe(addr string, rtt time.Duration, rcode int, err error) (time.Duration, bool) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	// if we already have latency data for this upstream, retrieve it.
+	// if we don't, initialize a new EWMA.
+	s, ok := e.stats[addr]
+	if !ok {
+		s = &stat{ewma: ewma.NewMovingAverage(e.Decay)}
+		e.stats[addr] = s
+	}
+	now := e.Now()
+	if s.warm(e.Warmup) {
+		// the time the upstream wasn't sampled counts, or an upstream that recovered
+		// would be averaged back into its old latency.
+		s.ewma.Set(e.estimate(addr, s, now))
+	}
+	// update the EWMA with the new round-trip time (rtt) measurement.
+	s.sampledAt = now
+	s.samples++
+	switch {
+	case s.samples < e.Warmup:
+		s.sum += float64(rtt)
+	case s.samples == e.Warmup || s.samples == 1:
+		// seed the EWMA with the mean of the warm-up samples, this also skips
+		// the fixed warm-up of ewma.VariableEWMA during which its value is 0.
+		s.ewma.Set((s.sum + float64(rtt)) / float64(s.samples))
+	default:
+		s.ewma.Add(float64(rtt))
+	}
+
+	if penalty := e.penaltyOf(rcode, err); penalty > 0 {
+		s.penalty = e.penalty(s, now) + float64(penalty)
+		s.penaltyAt = now
+	}
+	return time.Duration(s.ewma.Value()), s.warm(e.Warmup)
+}
+
+// EWMA returns the EWMA of the upstream at addr as of its last sample, or false if it was never sampled.
+func (e *Estimator) EWMA(addr string) (time.Duration, bool) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	s, ok := e.stats[addr]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(s.ewma.Value()), true
+}
+
+// Estimates returns the EWMA of each warm upstream at addrs as of now, indexed by address. Unlike
+// EWMA, it is decayed for the upstreams that aren't sampled anymore. Upstreams still warming up
+// are left out.
+func (e *Estimator) Estimates(addrs []string) map[string]time.Duration {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	now := e.Now()
+	estimates := make(map[string]time.Duration, len(addrs))
+	for _, addr := range addrs {
+		if s, ok := e.stats[addr]; ok && s.warm(e.Warmup) {
+			estimates[addr] = time.Duration(e.estimate(addr, s, now))
+		}
+	}
+	return estimates
+}
+
+// penaltyOf returns the penalty for an attempt that returned rcode and err.
+func (e *Estimator) penaltyOf(rcode int, err error) time.Duration {
+	if err != nil {
+		if e.isTimeout(err) {
+			return e.Penalties.Timeout
+		}
+		return e.Penalties.Err
+	}
+	if rcode == dns.RcodeServerFailure || rcode == dns.RcodeRefused {
+		return e.Penalties.Rcode
+	}
+	return 0
+}
+
+// warm returns true once the upstream has seen enough samples for its EWMA to be used.
+func (s *stat) warm(warmup int) bool {
+	return s.samples > 0 && s.samples >= warmup
+}
+
+// estimate returns the EWMA of the upstream at addr at time now. The EWMA of an upstream that isn't
+// sampled anymore decays towards the median EWMA of the upstreams sampled after it, the same kind
+// of prior a new upstream gets: what we knew about it matters less and less, but it isn't taken
+// for better than the upstreams measured since.
+func (e *Estimator) estimate(addr string, s *stat, now time.Time) float64 {
+	v := s.ewma.Value()
+	prior, ok := e.stalePrior(addr, s)
+	if !ok {
+		return v
+	}
+	return prior + decayed(v-prior, now.Sub(s.sampledAt), e.StaleHalfLife)
+}
+
+// stalePrior returns the median EWMA of the warm upstreams sampled after s, the stats of the
+// upstream at addr, or false if there are none.
+func (e *Estimator) stalePrior(addr string, s *stat) (float64, bool) {
+	var fresher []float64
+	for a, other := range e.stats {
+		if a != addr && other.warm(e.Warmup) && other.sampledAt.After(s.sampledAt) {
+			fresher = append(fresher, other.ewma.Value())
+		}
+	}
+	return Median(fresher), len(fresher) > 0
+}
+
+// penalty returns what is left at time now of the upstream's failure penalty.
+func (e *Estimator) penalty(s *stat, now time.Time) float64 {
+	return decayed(s.penalty, now.Sub(s.penaltyAt), e.Penalties.HalfLife)
+}
+
+// decayed returns what is left of v after elapsed, given that it halves every halfLife.
+// A halfLife of 0 means v doesn't decay.
+func decayed(v float64, elapsed, halfLife time.Duration) float64 {
+	if v == 0 || halfLife <= 0 || elapsed <= 0 {
+		return v
+	}
+	return v * math.Exp2(-float64(elapsed)/float64(halfLife))
+}
+
+// Median returns the median of values, or 0 if there are none.
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+var rn = rand.New(time.Now().UnixNano())
@@ -0,0 +1,290 @@
+package latency
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VividCortex/ewma"
+	"github.com/miekg/dns"
+)
+
+var errTimeout = errors.New("i/o timeout")
+
+// newTestEstimator returns an Estimator whose clock only moves when told to.
+func newTestEstimator() (*Estimator, *testClock) {
+	clock := &testClock{t: time.Now()}
+	e := New(func(err error) bool { return errors.Is(err, errTimeout) })
+	e.Now = clock.now
+	return e, clock
+}
+
+// setTestStat sets the EWMA of the upstream at addr to rtt, as sampled just now.
+func setTestStat(e *Estimator, addr string, rtt time.Duration, samples int) {
+	m := ewma.NewMovingAverage()
+	m.Set(float64(rtt))
+	e.stats[addr] = &stat{ewma: m, samples: samples, sampledAt: e.Now()}
+}
+
+// testClock is a clock that only moves when told to.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// fastest returns the address with the lowest value.
+func fastest(e *Estimator, addrs []string) string {
+	values := e.Values(addrs)
+	first := addrs[0]
+	for _, addr := range addrs[1:] {
+		if values[addr] < values[first] {
+			first = addr
+		}
+	}
+	return first
+}
+
+func TestPenaltyOf(t *testing.T) {
+	e, _ := newTestEstimator()
+	e.Penalties = Penalties{Timeout: 3 * time.Second, Rcode: 2 * time.Second, Err: time.Second}
+
+	tests := []struct {
+		name     string
+		rcode    int
+		err      error
+		expected time.Duration
+	}{
+		{"success", dns.RcodeSuccess, nil, 0},
+		{"nxdomain", dns.RcodeNameError, nil, 0},
+		{"servfail", dns.RcodeServerFailure, nil, 2 * time.Second},
+		{"refused", dns.RcodeRefused, nil, 2 * time.Second},
+		{"timeout", dns.RcodeServerFailure, errTimeout, 3 * time.Second},
+		{"connect error", dns.RcodeServerFailure, errors.New("connection refused"), time.Second},
+	}
+
+	for _, test := range tests {
+		if x := e.penaltyOf(test.rcode, test.err); x != test.expected {
+			t.Errorf("Test %q: expected penalty %s, got %s", test.name, test.expected, x)
+		}
+	}
+}
+
+func TestPenaltyDecay(t *testing.T) {
+	e, clock := newTestEstimator()
+	e.Penalties = Penalties{Rcode: time.Second, HalfLife: 10 * time.Second}
+
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53"}
+	failing, healthy := addrs[0], addrs[1]
+	setTestStat(e, failing, time.Millisecond, 1)
+	setTestStat(e, healthy, 100*time.Millisecond, 1)
+
+	e.Observe(failing, time.Millisecond, dns.RcodeServerFailure, nil)
+	if x := fastest(e, addrs); x != healthy {
+		t.Fatalf("Expected %s to be the fastest after a SERVFAIL, got %s", healthy, x)
+	}
+
+	clock.advance(10 * time.Second)
+	if x := time.Duration(e.penalty(e.stats[failing], clock.now())); x != 500*time.Millisecond {
+		t.Errorf("Expected penalty to halve after one half-life, got %s", x)
+	}
+
+	// A couple more half-lives and the penalty no longer outweighs the latency difference.
+	clock.advance(50 * time.Second)
+	if x := fastest(e, addrs); x != failing {
+		t.Errorf("Expected %s to be the fastest once its penalty decayed, got %s", failing, x)
+	}
+}
+
+func TestPenaltyAccumulates(t *testing.T) {
+	e, _ := newTestEstimator()
+	e.Penalties = Penalties{Err: time.Second, HalfLife: 10 * time.Second}
+
+	for i := 0; i < 3; i++ {
+		e.Observe("1.1.1.1:53", time.Millisecond, dns.RcodeServerFailure, errors.New("connection refused"))
+	}
+	if x := time.Duration(e.stats["1.1.1.1:53"].penalty); x != 3*time.Second {
+		t.Errorf("Expected penalties to add up to %s, got %s", 3*time.Second, x)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	e, _ := newTestEstimator()
+	e.Warmup = 3
+
+	for i, rtt := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+		if _, warm := e.Observe("1.1.1.1:53", rtt, dns.RcodeSuccess, nil); warm {
+			t.Fatalf("Expected upstream to be warming up after %d samples", i+1)
+		}
+	}
+	x, warm := e.Observe("1.1.1.1:53", 30*time.Millisecond, dns.RcodeSuccess, nil)
+	if !warm {
+		t.Fatal("Expected upstream to be warm after 3 samples")
+	}
+	if x != 20*time.Millisecond {
+		t.Errorf("Expected EWMA to be seeded with the warm-up mean %s, got %s", 20*time.Millisecond, x)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	// An age of 1 sample means the EWMA only remembers the last sample.
+	e, _ := newTestEstimator()
+	e.Decay = 1
+
+	e.Observe("1.1.1.1:53", 10*time.Millisecond, dns.RcodeSuccess, nil)
+	if x, _ := e.Observe("1.1.1.1:53", 50*time.Millisecond, dns.RcodeSuccess, nil); x != 50*time.Millisecond {
+		t.Errorf("Expected EWMA %s, got %s", 50*time.Millisecond, x)
+	}
+}
+
+func TestValuesWarmupPrior(t *testing.T) {
+	addrs := []string{
+		"1.1.1.1:53",
+		"2.2.2.2:53",
+		"3.3.3.3:53",
+		"4.4.4.4:53", // new
+		"5.5.5.5:53", // warming up
+	}
+
+	e, _ := newTestEstimator()
+	e.Warmup = 10
+	for i, rtt := range []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond} {
+		setTestStat(e, addrs[i], rtt, e.Warmup)
+	}
+	// a warming up upstream's EWMA is not trusted, even if it looks fast.
+	setTestStat(e, addrs[4], time.Millisecond, 1)
+
+	expected := []time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond}
+	values := e.Values(addrs)
+	for i, addr := range addrs {
+		if x := time.Duration(values[addr]); x != expected[i] {
+			t.Errorf("Expected %s to be ranked at %s, got %s", addr, expected[i], x)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values   []float64
+		expected float64
+	}{
+		{nil, 0},
+		{[]float64{3}, 3},
+		{[]float64{3, 1}, 2},
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	}
+	for i, test := range tests {
+		if x := Median(test.values); x != test.expected {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, x)
+		}
+	}
+}
+
+func TestStaleEstimateDecaysToPrior(t *testing.T) {
+	e, clock := newTestEstimator()
+	e.StaleHalfLife = 30 * time.Second
+
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53"}
+	fast, slow := addrs[0], addrs[1]
+	e.Observe(slow, 500*time.Millisecond, dns.RcodeSuccess, nil)
+	e.Observe(fast, 10*time.Millisecond, dns.RcodeSuccess, nil)
+
+	// Only the fastest upstream gets traffic, so only its estimate is refreshed.
+	for i := 0; i < 60; i++ {
+		clock.advance(5 * time.Second)
+		if x := fastest(e, addrs); x != fast {
+			t.Fatalf("Expected a stale upstream never to look better than the others, got %s", x)
+		}
+		e.Observe(fast, 10*time.Millisecond, dns.RcodeSuccess, nil)
+	}
+
+	// After 10 half-lives, what we knew about it is almost forgotten: it is back to the prior.
+	x := time.Duration(e.estimate(slow, e.stats[slow], clock.now()))
+	if x < 10*time.Millisecond || x > 11*time.Millisecond {
+		t.Errorf("Expected the estimate of %s to decay to the 10ms of the others, got %s", slow, x)
+	}
+}
+
+func TestStaleEstimateIsDecayedBeforeSampling(t *testing.T) {
+	e, clock := newTestEstimator()
+	e.StaleHalfLife = 30 * time.Second
+
+	e.Observe("2.2.2.2:53", 500*time.Millisecond, dns.RcodeSuccess, nil)
+	e.Observe("1.1.1.1:53", 10*time.Millisecond, dns.RcodeSuccess, nil)
+	clock.advance(5 * time.Minute)
+	e.Observe("1.1.1.1:53", 10*time.Millisecond, dns.RcodeSuccess, nil)
+
+	// the sample is added to the decayed estimate, close to 10ms, not to the 500ms of long ago.
+	if x, _ := e.Observe("2.2.2.2:53", time.Millisecond, dns.RcodeSuccess, nil); x > 20*time.Millisecond {
+		t.Errorf("Expected the EWMA of a recovered upstream to start from the prior, got %s", x)
+	}
+}
+
+func TestStaleHalfLifeDisabled(t *testing.T) {
+	e, clock := newTestEstimator()
+	e.StaleHalfLife = 0
+
+	e.Observe("1.1.1.1:53", 500*time.Millisecond, dns.RcodeSuccess, nil)
+	e.Observe("2.2.2.2:53", 10*time.Millisecond, dns.RcodeSuccess, nil)
+	clock.advance(time.Hour)
+	if x := time.Duration(e.estimate("1.1.1.1:53", e.stats["1.1.1.1:53"], clock.now())); x != 500*time.Millisecond {
+		t.Errorf("Expected estimate not to decay, got %s", x)
+	}
+}
+
+func TestExplored(t *testing.T) {
+	e, clock := newTestEstimator()
+
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53", "3.3.3.3:53"}
+	for _, addr := range []string{"3.3.3.3:53", "1.1.1.1:53", "2.2.2.2:53"} {
+		e.Observe(addr, 10*time.Millisecond, dns.RcodeSuccess, nil)
+		clock.advance(time.Second)
+	}
+
+	if _, ok := e.Explored(addrs); ok {
+		t.Error("Expected nothing to be explored with an explore of 0")
+	}
+	e.Explore = 1
+	// 1.1.1.1 is first, 3.3.3.3 is the least recently sampled of the others.
+	if i, ok := e.Explored(addrs); !ok || addrs[i] != "3.3.3.3:53" {
+		t.Errorf("Expected 3.3.3.3:53 to be explored, got %s", addrs[i])
+	}
+	// one that was never sampled comes before anything else.
+	addrs = append(addrs, "4.4.4.4:53")
+	if i, ok := e.Explored(addrs); !ok || addrs[i] != "4.4.4.4:53" {
+		t.Errorf("Expected the never sampled 4.4.4.4:53 to be explored, got %s", addrs[i])
+	}
+}
+
+func TestOrder(t *testing.T) {
+	e, clock := newTestEstimator()
+	e.Warmup = 1
+
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53", "3.3.3.3:53", "4.4.4.4:53"}
+	for _, rtt := range []struct {
+		addr string
+		rtt  time.Duration
+	}{
+		{"3.3.3.3:53", 10 * time.Millisecond},
+		{"1.1.1.1:53", 30 * time.Millisecond},
+		{"2.2.2.2:53", 20 * time.Millisecond},
+		{"4.4.4.4:53", 20 * time.Millisecond},
+	} {
+		e.Observe(rtt.addr, rtt.rtt, dns.RcodeSuccess, nil)
+		clock.advance(time.Second)
+	}
+
+	// fastest first, 2.2.2.2 and 4.4.4.4 keep their order.
+	order, explored := e.Order(addrs)
+	if explored || fmt.Sprint(order) != "[2 1 3 0]" {
+		t.Errorf("Expected the order [2 1 3 0], got %v, explored %v", order, explored)
+	}
+
+	// 1.1.1.1 is the least recently sampled after the first one, so it's moved up front.
+	e.Explore = 1
+	order, explored = e.Order(addrs)
+	if !explored || fmt.Sprint(order) != "[0 2 1 3]" {
+		t.Errorf("Expected the order [0 2 1 3], explored, got %v, explored %v", order, explored)
+	}
+}
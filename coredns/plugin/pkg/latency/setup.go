@@ -0,0 +1,94 @@
+package latency
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+// ParseBlock parses the optional block that may follow "policy NAME". It calls property
+// for each property in the block, with the dispenser on the property's name.
+func ParseBlock(c *caddy.Controller, property func() error) error {
+	if !c.NextArg() {
+		return nil
+	}
+	if c.Val() != "{" {
+		return c.ArgErr()
+	}
+	for c.Next() {
+		if c.Val() == "}" {
+			return nil
+		}
+		if err := property(); err != nil {
+			return err
+		}
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+	}
+	return c.EOFErr()
+}
+
+// ParseProperty parses a property of a latency policy into e, policy is the name used in errors.
+func (e *Estimator) ParseProperty(c *caddy.Controller, policy string) (err error) {
+	switch c.Val() {
+	case "timeout_penalty":
+		e.Penalties.Timeout, err = ParseDurationArg(c)
+	case "rcode_penalty":
+		e.Penalties.Rcode, err = ParseDurationArg(c)
+	case "error_penalty":
+		e.Penalties.Err, err = ParseDurationArg(c)
+	case "penalty_half_life":
+		e.Penalties.HalfLife, err = ParseDurationArg(c)
+	case "stale_half_life":
+		e.StaleHalfLife, err = ParseDurationArg(c)
+	case "explore":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		var percent float64
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(c.Val(), "%"), 64)
+		if err == nil && (percent < 0 || percent > 100) {
+			err = fmt.Errorf("explore must be between 0%% and 100%%: %s", c.Val())
+		}
+		e.Explore = percent / 100
+	case "decay":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		e.Decay, err = strconv.ParseFloat(c.Val(), 64)
+		if err == nil && e.Decay < 1 {
+			err = fmt.Errorf("decay can't be less than 1: %s", c.Val())
+		}
+	case "warmup":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		e.Warmup, err = strconv.Atoi(c.Val())
+		if err == nil && e.Warmup < 0 {
+			err = fmt.Errorf("warmup can't be negative: %d", e.Warmup)
+		}
+	default:
+		return c.Errf("unknown %s policy property '%s'", policy, c.Val())
+	}
+	return err
+}
+
+// ParseDurationArg parses the non-negative duration argument of the current property.
+func ParseDurationArg(c *caddy.Controller) (time.Duration, error) {
+	name := c.Val()
+	if !c.NextArg() {
+		return 0, c.ArgErr()
+	}
+	dur, err := time.ParseDuration(c.Val())
+	if err != nil {
+		return 0, err
+	}
+	if dur < 0 {
+		return 0, fmt.Errorf("%s can't be negative: %s", name, dur)
+	}
+	return dur, nil
+}
@@ -0,0 +1,87 @@
+package latency
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestParseProperty(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		expectedErr string
+	}{
+		// positive
+		{"policy latency", false, ""},
+		{"policy latency {\ntimeout_penalty 1s\nrcode_penalty 0s\n}", false, ""},
+		{"policy latency {\nstale_half_life 0s\nexplore 5%\n}", false, ""},
+		// negative
+		{"policy latency foo", true, "Wrong argument count"},
+		{"policy latency {\nfoo 1s\n}", true, "unknown latency policy property"},
+		{"policy latency {\ntimeout_penalty\n}", true, "Wrong argument count"},
+		{"policy latency {\nerror_penalty -1s\n}", true, "can't be negative"},
+		{"policy latency {\npenalty_half_life 1s 2s\n}", true, "Wrong argument count"},
+		{"policy latency {\ndecay 0.5\n}", true, "decay can't be less than 1"},
+		{"policy latency {\nwarmup -1\n}", true, "warmup can't be negative"},
+		{"policy latency {\nexplore 101%\n}", true, "explore must be between"},
+		{"policy latency {\nexplore some\n}", true, "invalid syntax"},
+		{"policy latency {\nwarmup 3\n", true, "Unexpected EOF"},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		c.Next() // policy
+		c.NextArg()
+		e := New(nil)
+		err := ParseBlock(c, func() error { return e.ParseProperty(c, "latency") })
+
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error but found none for input %s", i, test.input)
+		}
+		if err != nil {
+			if !test.shouldErr {
+				t.Errorf("Test %d: expected no error but found one for input %s, got: %v", i, test.input, err)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Test %d: expected error to contain: %v, found error: %v, input: %s", i, test.expectedErr, err, test.input)
+			}
+		}
+	}
+}
+
+func TestParsePropertyValues(t *testing.T) {
+	input := `policy latency {
+		timeout_penalty 3s
+		rcode_penalty 1s
+		error_penalty 4s
+		penalty_half_life 1m
+		stale_half_life 0
+		explore 5%
+		decay 10
+		warmup 3
+	}`
+	c := caddy.NewTestController("dns", input)
+	c.Next()
+	c.NextArg()
+	e := New(nil)
+	if err := ParseBlock(c, func() error { return e.ParseProperty(c, "latency") }); err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+
+	expected := Penalties{Timeout: 3 * time.Second, Rcode: time.Second, Err: 4 * time.Second, HalfLife: time.Minute}
+	if e.Penalties != expected {
+		t.Errorf("Expected penalties %+v, got %+v", expected, e.Penalties)
+	}
+	if e.StaleHalfLife != 0 {
+		t.Errorf("Expected stale half-life 0, got %s", e.StaleHalfLife)
+	}
+	if e.Explore != 0.05 {
+		t.Errorf("Expected explore 0.05, got %v", e.Explore)
+	}
+	if e.Decay != 10 || e.Warmup != 3 {
+		t.Errorf("Expected decay 10 and warmup 3, got %v and %d", e.Decay, e.Warmup)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	golog "log"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 // D controls whether we should output debug logs. If true, we do, once set
@@ -47,14 +48,29 @@ func (d *d) Value() bool {
 	return b
 }
 
+// logger is where the logs go, set with SetLogger. The std lib logger is used if it's nil.
+var logger atomic.Pointer[golog.Logger]
+
+// SetLogger sends the logs to l instead of the std lib logger, so a program running CoreDNS
+// in its own process keeps its logs apart. A nil l sends them back to the std lib logger.
+func SetLogger(l *golog.Logger) { logger.Store(l) }
+
+// output returns the logger the logs go to.
+func output() *golog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	return golog.Default()
+}
+
 // logf calls log.Printf prefixed with level.
 func logf(level, format string, v ...interface{}) {
-	golog.Print(level, fmt.Sprintf(format, v...))
+	output().Print(level, fmt.Sprintf(format, v...))
 }
 
 // log calls log.Print prefixed with level.
 func log(level string, v ...interface{}) {
-	golog.Print(level, fmt.Sprint(v...))
+	output().Print(level, fmt.Sprint(v...))
 }
 
 // Debug is equivalent to log.Print(), but prefixed with "[DEBUG] ". It only outputs something
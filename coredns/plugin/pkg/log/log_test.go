@@ -70,3 +70,24 @@ func TestLevels(t *testing.T) {
 		t.Errorf("Expected log to be %s, got %s", err+ts, x)
 	}
 }
+
+func TestSetLogger(t *testing.T) {
+	var std, own bytes.Buffer
+	golog.SetOutput(&std)
+	SetLogger(golog.New(&own, "", 0))
+	defer SetLogger(nil)
+
+	Info("info")
+	if x := own.String(); x != info+"info\n" {
+		t.Errorf("Expected the log to go to the logger set, got %q", x)
+	}
+	if x := std.String(); x != "" {
+		t.Errorf("Expected nothing to go to the std lib logger, got %q", x)
+	}
+
+	SetLogger(nil)
+	Info("info")
+	if x := std.String(); !strings.Contains(x, info+"info") {
+		t.Errorf("Expected the log to go back to the std lib logger, got %q", x)
+	}
+}
@@ -75,6 +75,8 @@ func (t *Transport) Dial(proto string) (*persistConn, bool, error) {
 // Connect selects an upstream, sends the request and waits for a response.
 func (p *Proxy) Connect(ctx context.Context, state request.Request, opts Options) (*dns.Msg, error) {
 	start := time.Now()
+	atomic.AddInt64(&p.inflight, 1)
+	defer atomic.AddInt64(&p.inflight, -1)
 
 	proto := ""
 	switch {
@@ -90,6 +92,10 @@ func (p *Proxy) Connect(ctx context.Context, state request.Request, opts Options
 	if err != nil {
 		return nil, err
 	}
+	// Dial uses TLS whenever it is configured, whatever the protocol of the query.
+	if p.transport.tlsConfig != nil {
+		proto = "tcp-tls"
+	}
 
 	// Set buffer size correctly for this client.
 	pc.c.UDPSize = uint16(state.Size())
@@ -12,6 +12,8 @@ import (
 
 // Proxy defines an upstream host.
 type Proxy struct {
+	inflight int64 // atomic counters need to be first in struct for proper alignment
+
 	fails uint32
 	addr  string
 
@@ -57,6 +59,11 @@ func (p *Proxy) Fails() uint32 {
 	return atomic.LoadUint32(&p.fails)
 }
 
+// InFlight returns the number of requests that are currently being sent to this proxy.
+func (p *Proxy) InFlight() int64 {
+	return atomic.LoadInt64(&p.inflight)
+}
+
 // Healthcheck kicks of a round of health checks for this proxy.
 func (p *Proxy) Healthcheck() {
 	if p.health == nil {
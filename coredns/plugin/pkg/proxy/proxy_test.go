@@ -71,6 +71,54 @@ func TestProxyTLSFail(t *testing.T) {
 	}
 }
 
+func TestProxyTLSClosedByPeer(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../../tls/test_cert.pem", "../../tls/test_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// read the query, then close the connection without answering.
+			co := &dns.Conn{Conn: conn}
+			co.ReadMsg()
+			co.Close()
+		}
+	}()
+
+	p := NewProxy(l.Addr().String(), transport.TLS)
+	p.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	p.Start(5 * time.Second)
+	defer p.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	// the query comes over UDP, but goes upstream over TLS.
+	req := request.Request{Req: m, W: &test.ResponseWriter{}}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Connect(context.Background(), req, Options{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error when the upstream closes the connection, got none")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Connect to return when the upstream closes the connection")
+	}
+}
+
 func TestProtocolSelection(t *testing.T) {
 	p := NewProxy("bad_address", transport.DNS)
 	p.readTimeout = 10 * time.Millisecond
@@ -128,3 +176,40 @@ func TestProxyIncrementFails(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyInFlight(t *testing.T) {
+	release := make(chan struct{})
+	s := dnstest.NewServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		<-release
+		ret := new(dns.Msg)
+		ret.SetReply(r)
+		w.WriteMsg(ret)
+	})
+	defer s.Close()
+
+	p := NewProxy(s.Addr, transport.DNS)
+	p.Start(5 * time.Second)
+	defer p.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	req := request.Request{Req: m, W: &test.ResponseWriter{}}
+
+	done := make(chan struct{})
+	go func() {
+		p.Connect(context.Background(), req, Options{})
+		close(done)
+	}()
+
+	for i := 0; p.InFlight() != 1; i++ {
+		if i == 100 {
+			t.Fatalf("Expected 1 request in flight, got %d", p.InFlight())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	<-done
+	if x := p.InFlight(); x != 0 {
+		t.Errorf("Expected no requests in flight, got %d", x)
+	}
+}
@@ -33,3 +33,12 @@ func (r *Rand) Perm(n int) []int {
 	r.m.Unlock()
 	return v
 }
+
+// Float64 returns, as a float64, a pseudo-random number in the half-open interval [0.0,1.0)
+// from the Source in Rand.r.
+func (r *Rand) Float64() float64 {
+	r.m.Lock()
+	v := r.r.Float64()
+	r.m.Unlock()
+	return v
+}
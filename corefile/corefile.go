@@ -0,0 +1,50 @@
+// Package corefile adapts the Corefiles in conf/ to the tester: the ports
+// CoreDNS listens on and the upstreams it forwards to.
+package corefile
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// serverBlockPort matches the port of a server block, like ".:8054 {".
+	serverBlockPort = regexp.MustCompile(`(?m)^(\S*):\d+(\s*\{)`)
+	// prometheusLine matches the prometheus plugin line.
+	prometheusLine = regexp.MustCompile(`(?m)^(\s*prometheus)\b.*$`)
+	// policyLine matches the policy of the forward or grpc plugin.
+	policyLine = regexp.MustCompile(`(?m)^\s*policy\s+(\S+)`)
+	// proxyLine matches the forward or grpc plugin line, with its upstreams.
+	proxyLine = regexp.MustCompile(`(?m)^(\s*(?:forward|grpc)\s+\S+)((?:[ \t]+[^\s{]+)+)([ \t]*\{?[ \t]*)$`)
+)
+
+// SetPort makes the server blocks of the Corefile listen on port.
+func SetPort(corefile string, port int) string {
+	return serverBlockPort.ReplaceAllString(corefile, "${1}:"+strconv.Itoa(port)+"${2}")
+}
+
+// SetMetricsPort makes the prometheus plugin of the Corefile export the metrics on port.
+func SetMetricsPort(corefile string, port int) string {
+	return prometheusLine.ReplaceAllString(corefile, "${1} :"+strconv.Itoa(port))
+}
+
+// SetUpstreams makes the forward and grpc plugins of the Corefile proxy to upstreams.
+func SetUpstreams(corefile string, upstreams []string) string {
+	return proxyLine.ReplaceAllString(corefile, "${1} "+strings.Join(upstreams, " ")+"${3}")
+}
+
+// ForwardsOverTLS returns whether the forward plugin of the Corefile forwards to
+// its upstreams over TLS, so it needs their TLS addresses.
+func ForwardsOverTLS(corefile string) bool {
+	m := proxyLine.FindStringSubmatch(corefile)
+	return m != nil && strings.HasPrefix(strings.TrimSpace(m[2]), "tls://")
+}
+
+// Policy returns the policy of the Corefile, or "" if it doesn't set any.
+func Policy(corefile string) string {
+	if m := policyLine.FindStringSubmatch(corefile); m != nil {
+		return m[1]
+	}
+	return ""
+}
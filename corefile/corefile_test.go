@@ -0,0 +1,60 @@
+package corefile
+
+import "testing"
+
+const latencyCorefile = `.:8054 {
+    forward . 127.0.0.1:8051 127.0.0.1:8052 127.0.0.1:8053 {
+        policy latency
+    }
+    log
+    prometheus :9153
+}
+`
+
+func TestAdapt(t *testing.T) {
+	expected := `.:8060 {
+    forward . 127.0.0.1:1 127.0.0.1:2 {
+        policy latency
+    }
+    log
+    prometheus :9160
+}
+`
+	got := SetUpstreams(SetMetricsPort(SetPort(latencyCorefile, 8060), 9160), []string{"127.0.0.1:1", "127.0.0.1:2"})
+	if got != expected {
+		t.Errorf("Expected corefile:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestSetUpstreamsWithoutBlock(t *testing.T) {
+	expected := ".:53 {\n    forward . 10.0.0.1\n}\n"
+	if got := SetUpstreams(".:53 {\n    forward . 1.1.1.1 8.8.8.8\n}\n", []string{"10.0.0.1"}); got != expected {
+		t.Errorf("Expected corefile:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestForwardsOverTLS(t *testing.T) {
+	if ForwardsOverTLS(latencyCorefile) {
+		t.Error("Expected a Corefile forwarding to plain DNS upstreams not to forward over TLS")
+	}
+	tlsCorefile := ".:53 {\n    forward . tls://127.0.0.1:9051 tls://127.0.0.1:9052 {\n        tls conf/upstreams.crt\n    }\n}\n"
+	if !ForwardsOverTLS(tlsCorefile) {
+		t.Error("Expected a Corefile forwarding to tls:// upstreams to forward over TLS")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		corefile string
+		expected string
+	}{
+		{latencyCorefile, "latency"},
+		{".:53 {\n    forward . 1.1.1.1 {\n        policy weighted_latency {\n            temperature 10ms\n        }\n    }\n}\n", "weighted_latency"},
+		{".:53 {\n    forward . 1.1.1.1\n}\n", ""},
+	}
+	for i, test := range tests {
+		if got := Policy(test.corefile); got != test.expected {
+			t.Errorf("Test %d: expected policy %q, got %q", i, test.expected, got)
+		}
+	}
+}
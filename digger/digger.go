@@ -1,52 +1,216 @@
 package digger
 
 import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Outcomes of a query, as labeled in the query duration histogram.
+const (
+	Success = "success"
+	Failure = "failure"
+	Timeout = "timeout"
+	Error   = "error"
+)
+
+var (
+	dnsQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tester_dns_query_duration_seconds",
+		Help:    "Time taken for dns query, as seen by the tester.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"rcode", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(dnsQueryDuration)
+}
+
+// dohPath and dohContentType are where and how DNS over HTTPS queries are sent, as in RFC 8484.
+const (
+	dohPath        = "/dns-query"
+	dohContentType = "application/dns-message"
+)
+
+// Transports a digger can query over.
+const (
+	UDP   = "udp"
+	TCP   = "tcp"
+	TLS   = "tls"
+	HTTPS = "https"
+)
+
+// Transports are all transports, in the order they are documented in.
+var Transports = []string{UDP, TCP, TLS, HTTPS}
+
+// timeout is how long a query over HTTPS has to be answered, like the
+// dial, write and read timeouts of a dns.Client.
+const timeout = 2 * time.Second
+
 type Digger struct {
 	logger     *log.Logger
 	targetHost string
+	transport  string
 	dnsClient  *dns.Client
+	// httpClient and url are set when querying over HTTPS.
+	httpClient *http.Client
+	url        string
 }
 
 func New(logger *log.Logger, targetHost string) *Digger {
 	return &Digger{
 		logger:     logger,
 		targetHost: targetHost,
+		transport:  UDP,
 		dnsClient:  new(dns.Client),
 	}
 }
 
+// NewWithTransport creates a Digger that queries targetHost over transport. Over TLS and
+// HTTPS, the certificate of targetHost is verified with tlsConfig. Over HTTPS, queries
+// are POSTed to the DNS over HTTPS path of targetHost, reusing connections.
+func NewWithTransport(logger *log.Logger, targetHost, transport string, tlsConfig *tls.Config) (*Digger, error) {
+	d := New(logger, targetHost)
+	d.transport = transport
+	switch transport {
+	case UDP:
+	case TCP:
+		d.dnsClient.Net = "tcp"
+	case TLS:
+		d.dnsClient.Net = "tcp-tls"
+		d.dnsClient.TLSConfig = tlsConfig
+	case HTTPS:
+		d.httpClient = &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
+		}
+		d.url = fmt.Sprintf("https://%s%s", targetHost, dohPath)
+	default:
+		return nil, fmt.Errorf(`unknown transport "%s"`, transport)
+	}
+	return d, nil
+}
+
+// Result is the outcome of a successful dig.
+type Result struct {
+	// RTT is the round-trip time of the query.
+	RTT time.Duration
+	// Upstream is the name of the DNS server that answered, as it identifies itself
+	// in the additional section. It is empty if it didn't.
+	Upstream string
+}
+
+// RcodeError is returned when the answer to a query has an rcode other than NOERROR.
+type RcodeError struct {
+	Domain string
+	Rcode  int
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf(`doing dns lookup for domain "%s", code: %d`, e.Domain, e.Rcode)
+}
+
+// Classify returns the rcode and the outcome of a query that returned err.
+// The rcode is "none" when there was no answer.
+func Classify(err error) (rcode, outcome string) {
+	if err == nil {
+		return dns.RcodeToString[dns.RcodeSuccess], Success
+	}
+	var rcodeErr *RcodeError
+	if errors.As(err, &rcodeErr) {
+		return dns.RcodeToString[rcodeErr.Rcode], Failure
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "none", Timeout
+	}
+	return "none", Error
+}
+
+// Observe records the duration of a query that returned err in the query duration histogram.
+func Observe(duration time.Duration, err error) {
+	rcode, outcome := Classify(err)
+	dnsQueryDuration.With(prometheus.Labels{"rcode": rcode, "outcome": outcome}).Observe(duration.Seconds())
+}
+
 func (d *Digger) Dig(domain string) error {
+	_, err := d.Query(domain)
+	return err
+}
+
+// Query digs the given domain, like Dig, and returns how it went.
+func (d *Digger) Query(domain string) (*Result, error) {
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
 	m.RecursionDesired = true
 
 	start := time.Now()
-	r, t, err := d.dnsClient.Exchange(m, d.targetHost)
+	r, t, err := d.exchange(m)
 	if err != nil {
-		return errors.Wrapf(err, `calling dns.Exchange for domain "%s"`, domain)
+		return nil, errors.Wrapf(err, `calling dns.Exchange for domain "%s"`, domain)
 	}
 
 	if r.Rcode != dns.RcodeSuccess {
-		return errors.Wrapf(err, `doing dns lookup for domain "%s", code: %d`, domain, r.Rcode)
+		return nil, &RcodeError{Domain: domain, Rcode: r.Rcode}
 	}
 
 	for _, ans := range r.Answer {
 		Arecord, ok := ans.(*dns.A)
 		if ok {
 			d.logger.Printf("IP address: %s -- query time: %v msec "+
-				"-- server: %s#%s (UDP) -- when: %s\n",
+				"-- server: %s (%s) -- when: %s\n",
 				Arecord.A, t.Milliseconds(),
-				d.dnsClient.Net,
-				d.targetHost, start.Format("Mon Jan _2 15:04:05 -07 2006"))
+				d.targetHost, strings.ToUpper(d.transport),
+				start.Format("Mon Jan _2 15:04:05 -07 2006"))
+		}
+	}
+
+	result := &Result{RTT: t}
+	for _, extra := range r.Extra {
+		if txt, ok := extra.(*dns.TXT); ok && len(txt.Txt) > 0 {
+			result.Upstream = txt.Txt[0]
 		}
 	}
+	return result, nil
+}
 
-	return nil
+// exchange sends m over the transport of the digger and returns the answer and how long it took.
+func (d *Digger) exchange(m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	if d.httpClient == nil {
+		return d.dnsClient.Exchange(m, d.targetHost)
+	}
+	// RFC 8484 recommends an ID of 0, so answers can be cached.
+	m.Id = 0
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
+	resp, err := d.httpClient.Post(d.url, dohContentType, bytes.NewReader(buf))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DNS over HTTPS answer with status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, 0, err
+	}
+	return r, time.Since(start), nil
 }
@@ -1,8 +1,12 @@
 package dnsserver
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -39,6 +43,20 @@ var (
 		Help:    "Time taken for dns request",
 		Buckets: prometheus.DefBuckets,
 	}, []string{"server"})
+	dnsTransportRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_transport_requests_total",
+			Help: "Number of DNS requests, by transport.",
+		},
+		[]string{"server", "transport"},
+	)
+	dnsConnections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_connections_total",
+			Help: "Number of connections accepted, by transport.",
+		},
+		[]string{"server", "transport"},
+	)
 )
 
 func init() {
@@ -46,13 +64,27 @@ func init() {
 	prometheus.MustRegister(serverStops)
 	prometheus.MustRegister(serverStarts)
 	prometheus.MustRegister(dnsRequestDuration)
+	prometheus.MustRegister(dnsTransportRequests)
+	prometheus.MustRegister(dnsConnections)
 }
 
 type Server struct {
-	name        string
-	port        int
-	latency     time.Duration
-	dnsSrv      *dns.Server
+	name    string
+	port    int
+	latency LatencyModel
+	jitter  time.Duration
+	faults  map[FaultMode]Fault
+	dnsSrv  *dns.Server
+	tcpSrv  *dns.Server
+	// cert, tlsPort and httpsPort are set when the server also answers DNS over TLS and HTTPS.
+	cert      *tls.Certificate
+	tlsPort   int
+	httpsPort int
+	tlsSrv    *dns.Server
+	httpsSrv  *http.Server
+	// drips are the delays between the bytes of the reply being written on each TCP
+	// and TLS connection, by the address of its client.
+	drips       sync.Map
 	logFileName string
 	logger      *log.Logger
 
@@ -67,17 +99,41 @@ func NewServer(name string, port int, latency int) (*Server, error) {
 		return nil, errors.Wrapf(err, `opening log file "%s"`, logFileName)
 	}
 	logger := log.New(logFile, "TESTER: ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+	s := NewServerWithLogger(name, port, latency, logger)
+	s.logFileName = logFileName
+	return s, nil
+}
+
+// NewServerWithLogger creates a new Server that logs to the given logger
+// instead of its own file in logs/.
+func NewServerWithLogger(name string, port int, latency int, logger *log.Logger) *Server {
 	return &Server{
-		name:        name,
-		port:        port,
-		latency:     time.Duration(latency) * time.Millisecond,
-		logger:      logger,
-		logFileName: logFileName,
-	}, nil
+		name:    name,
+		port:    port,
+		latency: Constant(time.Duration(latency) * time.Millisecond),
+		logger:  logger,
+	}
+}
+
+// handler returns the handler of the queries that come over transport.
+func (s *Server) handler(transport string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		dnsTransportRequests.With(prometheus.Labels{"server": s.name, "transport": transport}).Inc()
+		if transport == TCP || transport == TLS {
+			w = &connResponseWriter{ResponseWriter: w, s: s}
+		}
+		s.handleDNSRequest(w, r)
+	})
 }
 
 func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	start := time.Now()
+	fault, spike := s.pickFaults()
+	if fault != nil && fault.Mode == Drop {
+		s.logger.Printf(`server "%s" dropping the request`, s.name)
+		faultCounters[Drop].With(prometheus.Labels{"server": s.name}).Inc()
+		return
+	}
 	const recordA = "example.net. 3600 IN A 1.2.3.4"
 	m := new(dns.Msg)
 	m.SetReply(r)
@@ -89,9 +145,26 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 			m.Answer = append(m.Answer, rr)
 		}
 	}
+	// tell the tester which server answered, so it can see how a policy spreads the queries.
+	m.Extra = append(m.Extra, &dns.TXT{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{s.name},
+	})
 
-	s.logger.Printf(`server "%s" sleeping %s before serving the request...`, s.name, s.latency)
-	time.Sleep(s.latency)
+	latency := s.GetLatencyModel().Sample()
+	if jitter := s.GetJitter(); jitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(jitter) + 1))
+	}
+	if spike != nil {
+		latency += spike.Delay
+		faultCounters[Spike].With(prometheus.Labels{"server": s.name}).Inc()
+	}
+	if fault != nil && s.applyFault(fault, w, m) {
+		s.logger.Printf(`server "%s" injecting %s fault`, s.name, fault.Mode)
+		faultCounters[fault.Mode].With(prometheus.Labels{"server": s.name}).Inc()
+	}
+	s.logger.Printf(`server "%s" sleeping %s before serving the request...`, s.name, latency)
+	time.Sleep(latency)
 	err := w.WriteMsg(m)
 	if err != nil {
 		s.logger.Printf(`server "%s" failed to write message: %s`, s.name, err)
@@ -103,38 +176,140 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 }
 
 func (s *Server) Run() {
+	addr := fmt.Sprintf(":%d", s.port)
 	dnsSrv := &dns.Server{
-		Addr:    fmt.Sprintf(":%d", s.port),
+		Addr:    addr,
 		Net:     "udp",
-		Handler: dns.HandlerFunc(s.handleDNSRequest),
+		Handler: s.handler(UDP),
 	}
-	s.dnsSrv = dnsSrv
 	go func() {
 		if err := dnsSrv.ListenAndServe(); err != nil {
 			s.logger.Fatalf("Failed to start server: %s", err.Error())
 		}
 	}()
-
+	// TCP, for clients retrying truncated answers and for dripped replies.
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.logger.Fatalf("Failed to start TCP server: %s", err.Error())
+	}
+	tcpSrv := &dns.Server{
+		Listener: &dripListener{Listener: listener, s: s, transport: TCP},
+		Net:      "tcp",
+		Handler:  s.handler(TCP),
+	}
+	go func() {
+		if err := tcpSrv.ActivateAndServe(); err != nil {
+			s.logger.Printf("TCP server stopped: %s", err.Error())
+		}
+	}()
 	s.logger.Printf(`main: server "%s" listening on port %d`, s.name, s.port)
-	s.logger.Printf("main: this server has a latency of %s\n", s.latency)
+
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	if s.cert != nil {
+		s.tlsSrv, s.httpsSrv = s.runTLS()
+	}
+	s.logger.Printf("main: this server has a latency of %s\n", s.latency)
+	s.dnsSrv = dnsSrv
+	s.tcpSrv = tcpSrv
 	s.isRunning = true
 	serverStarts.With(prometheus.Labels{"server": s.name}).Inc()
 }
 
 func (s *Server) Stop() error {
 	s.mux.Lock()
-	defer s.mux.Unlock()
 	s.isRunning = false
+	dnsSrv, tcpSrv, tlsSrv, httpsSrv := s.dnsSrv, s.tcpSrv, s.tlsSrv, s.httpsSrv
+	s.tlsSrv, s.httpsSrv = nil, nil
+	s.mux.Unlock()
 	serverStops.With(prometheus.Labels{"server": s.name}).Inc()
-	return s.dnsSrv.Shutdown()
+
+	// shutting down waits for the queries being answered, which need the lock to finish.
+	err := dnsSrv.Shutdown()
+	if tcpErr := tcpSrv.Shutdown(); err == nil {
+		err = tcpErr
+	}
+	if tlsSrv != nil {
+		if tlsErr := tlsSrv.Shutdown(); err == nil {
+			err = tlsErr
+		}
+		// Close instead of Shutdown, which would wait for the dropped queries to give up.
+		if httpsErr := httpsSrv.Close(); err == nil {
+			err = httpsErr
+		}
+	}
+	return err
+}
+
+// runTLS starts answering DNS over TLS on tlsPort and DNS over HTTPS on httpsPort.
+func (s *Server) runTLS() (*dns.Server, *http.Server) {
+	config := &tls.Config{Certificates: []tls.Certificate{*s.cert}, MinVersion: tls.VersionTLS12}
+	listen := func(port int, transport string) net.Listener {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			s.logger.Fatalf("Failed to start %s server: %s", transport, err.Error())
+		}
+		// drip below TLS, so a dripped DoT answer is written a byte of a TLS record at a time.
+		// A dripped DoH answer is written a byte of its body at a time by its response writer.
+		return tls.NewListener(&dripListener{Listener: listener, s: s, transport: transport}, config)
+	}
+
+	tlsSrv := &dns.Server{
+		Listener: listen(s.tlsPort, TLS),
+		Net:      "tcp-tls",
+		Handler:  s.handler(TLS),
+	}
+	go func() {
+		if err := tlsSrv.ActivateAndServe(); err != nil {
+			s.logger.Printf("TLS server stopped: %s", err.Error())
+		}
+	}()
+	httpsListener := listen(s.httpsPort, HTTPS)
+	httpsSrv := &http.Server{
+		Handler:  dohHandler(s.handler(HTTPS)),
+		ErrorLog: s.logger,
+	}
+	go func() {
+		if err := httpsSrv.Serve(httpsListener); err != nil && err != http.ErrServerClosed {
+			s.logger.Printf("HTTPS server stopped: %s", err.Error())
+		}
+	}()
+	s.logger.Printf(`main: server "%s" listening on port %d for DNS over TLS and %d for DNS over HTTPS`, s.name, s.tlsPort, s.httpsPort)
+	return tlsSrv, httpsSrv
 }
 
 func (s *Server) GetName() string {
 	return s.name
 }
 
+func (s *Server) GetPort() int {
+	return s.port
+}
+
+// SetTLS makes the server also answer DNS over TLS on tlsPort and DNS over HTTPS
+// on httpsPort, with cert, once it runs.
+func (s *Server) SetTLS(cert tls.Certificate, tlsPort, httpsPort int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.cert = &cert
+	s.tlsPort = tlsPort
+	s.httpsPort = httpsPort
+}
+
+// GetTLSPort returns the DNS over TLS port of the server, 0 if it doesn't answer DNS over TLS.
+func (s *Server) GetTLSPort() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.tlsPort
+}
+
+// GetHTTPSPort returns the DNS over HTTPS port of the server, 0 if it doesn't answer DNS over HTTPS.
+func (s *Server) GetHTTPSPort() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.httpsPort
+}
+
 func (s *Server) GetLogFileName() string {
 	return s.logFileName
 }
@@ -145,8 +320,43 @@ func (s *Server) IsRunning() bool {
 	return s.isRunning
 }
 
+// GetLatency returns the median latency of the server.
+func (s *Server) GetLatency() time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.latency.Median()
+}
+
+// SetLatency moves the latency model of the server so its median is latency,
+// keeping the shape of the distribution.
 func (s *Server) SetLatency(latency time.Duration) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.latency = latency
+	s.latency = s.latency.WithMedian(latency)
+}
+
+func (s *Server) GetLatencyModel() LatencyModel {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.latency
+}
+
+// SetLatencyModel makes the server sample the latency of each answer from model.
+func (s *Server) SetLatencyModel(model LatencyModel) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.latency = model
+}
+
+func (s *Server) GetJitter() time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.jitter
+}
+
+// SetJitter makes the server add a random delay, up to jitter, to the latency of each answer.
+func (s *Server) SetJitter(jitter time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.jitter = jitter
 }
@@ -0,0 +1,244 @@
+package dnsserver
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// FaultMode is a way a server misbehaves on some of the queries.
+type FaultMode string
+
+const (
+	// Drop doesn't answer the query at all.
+	Drop FaultMode = "drop"
+	// ServFail, Refused and NXDomain answer with that rcode and no records.
+	ServFail FaultMode = "servfail"
+	Refused  FaultMode = "refused"
+	NXDomain FaultMode = "nxdomain"
+	// Truncate answers a UDP query with the TC bit set and no records.
+	Truncate FaultMode = "truncate"
+	// BadID answers with another ID than the query's. Clients skip such a reply,
+	// so the query times out, unlike BadQuestion.
+	BadID FaultMode = "bad_id"
+	// BadQuestion answers with another question than the query's.
+	BadQuestion FaultMode = "bad_question"
+	// Spike adds Delay to the latency of the answer, making a long tail.
+	Spike FaultMode = "spike"
+	// Drip writes the answer to a TCP, TLS or HTTPS query one byte at a time, Delay apart.
+	Drip FaultMode = "drip"
+)
+
+// FaultModes are all fault modes, in the order a query is checked against them.
+// Spike is checked on its own, so it can add up with any other mode.
+var FaultModes = []FaultMode{Drop, ServFail, Refused, NXDomain, Truncate, BadID, BadQuestion, Drip, Spike}
+
+// Fault makes a server misbehave, in the given Mode, on a Rate of the queries.
+type Fault struct {
+	Mode FaultMode
+	// Rate is the fraction of the queries, from 0 to 1, the fault applies to.
+	Rate float64
+	// Delay is the extra latency of a spike, or the time between the bytes of a drip.
+	Delay time.Duration
+}
+
+// Validate checks the fault makes sense.
+func (f Fault) Validate() error {
+	known := false
+	for _, mode := range FaultModes {
+		known = known || mode == f.Mode
+	}
+	if !known {
+		return fmt.Errorf(`unknown fault mode "%s"`, f.Mode)
+	}
+	if f.Rate < 0 || f.Rate > 1 {
+		return fmt.Errorf("fault rate must be between 0 and 1: %v", f.Rate)
+	}
+	if f.Delay < 0 {
+		return fmt.Errorf("fault delay can't be negative: %s", f.Delay)
+	}
+	if (f.Mode == Spike || f.Mode == Drip) && f.Rate > 0 && f.Delay == 0 {
+		return fmt.Errorf("%s fault needs a delay", f.Mode)
+	}
+	return nil
+}
+
+var faultCounters = map[FaultMode]*prometheus.CounterVec{
+	Drop:        newFaultCounter("dropped_queries_total", "Number of queries dropped on purpose."),
+	ServFail:    newFaultCounter("servfail_replies_total", "Number of SERVFAIL replies sent on purpose."),
+	Refused:     newFaultCounter("refused_replies_total", "Number of REFUSED replies sent on purpose."),
+	NXDomain:    newFaultCounter("nxdomain_replies_total", "Number of NXDOMAIN replies sent on purpose."),
+	Truncate:    newFaultCounter("truncated_replies_total", "Number of truncated replies sent on purpose."),
+	BadID:       newFaultCounter("bad_id_replies_total", "Number of replies sent with a mismatched ID on purpose."),
+	BadQuestion: newFaultCounter("bad_question_replies_total", "Number of replies sent with a mismatched question on purpose."),
+	Spike:       newFaultCounter("latency_spikes_total", "Number of replies delayed by a latency spike on purpose."),
+	Drip:        newFaultCounter("dripped_replies_total", "Number of TCP, TLS and HTTPS replies written slowly on purpose."),
+}
+
+func newFaultCounter(name, help string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fault_" + name,
+			Help: help,
+		},
+		[]string{"server"},
+	)
+}
+
+func init() {
+	for _, mode := range FaultModes {
+		prometheus.MustRegister(faultCounters[mode])
+	}
+}
+
+// SetFault sets the fault of its mode, replacing the previous one. A fault with a Rate of 0 clears it.
+func (s *Server) SetFault(f Fault) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if f.Rate == 0 {
+		delete(s.faults, f.Mode)
+		return nil
+	}
+	if s.faults == nil {
+		s.faults = make(map[FaultMode]Fault)
+	}
+	s.faults[f.Mode] = f
+	return nil
+}
+
+// ClearFaults makes the server behave again.
+func (s *Server) ClearFaults() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.faults = nil
+}
+
+// GetFaults returns the faults of the server, in the order of FaultModes.
+func (s *Server) GetFaults() []Fault {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var faults []Fault
+	for _, mode := range FaultModes {
+		if f, ok := s.faults[mode]; ok {
+			faults = append(faults, f)
+		}
+	}
+	return faults
+}
+
+// pickFaults returns the fault the query gets, if any, and the spike it gets, if any.
+func (s *Server) pickFaults() (fault, spike *Fault) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, mode := range FaultModes {
+		f, ok := s.faults[mode]
+		if !ok || rand.Float64() >= f.Rate {
+			continue
+		}
+		if mode == Spike {
+			spike = &f
+		} else if fault == nil {
+			fault = &f
+		}
+	}
+	return fault, spike
+}
+
+// applyFault changes the reply m to w as the fault says. It returns false if the
+// fault doesn't apply to the transport of the query.
+func (s *Server) applyFault(f *Fault, w dns.ResponseWriter, m *dns.Msg) bool {
+	_, tcp := w.RemoteAddr().(*net.TCPAddr)
+	switch f.Mode {
+	case ServFail:
+		m.Rcode, m.Answer = dns.RcodeServerFailure, nil
+	case Refused:
+		m.Rcode, m.Answer = dns.RcodeRefused, nil
+	case NXDomain:
+		m.Rcode, m.Answer = dns.RcodeNameError, nil
+	case Truncate:
+		if tcp {
+			return false
+		}
+		m.Truncated, m.Answer = true, nil
+	case BadID:
+		m.Id++
+	case BadQuestion:
+		m.Question[0].Name = "mismatch." + m.Question[0].Name
+	case Drip:
+		d, ok := w.(dripper)
+		if !ok {
+			return false
+		}
+		d.drip(f.Delay)
+	}
+	return true
+}
+
+// dripper is a response writer that can write the reply to its query one byte at a time.
+type dripper interface {
+	drip(delay time.Duration)
+}
+
+// connResponseWriter answers a query over a TCP or TLS connection. The queries of a
+// connection are answered one at a time, so a drip of the connection is only for
+// the reply to this query.
+type connResponseWriter struct {
+	dns.ResponseWriter
+	s *Server
+}
+
+func (w *connResponseWriter) drip(delay time.Duration) {
+	w.s.drips.Store(w.RemoteAddr().String(), delay)
+}
+
+func (w *connResponseWriter) WriteMsg(m *dns.Msg) error {
+	// a reply that failed before being written doesn't leave its drip to the next one.
+	defer w.s.drips.Delete(w.RemoteAddr().String())
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// dripListener hands out connections that drip the replies the server asks them to,
+// counting them by transport.
+type dripListener struct {
+	net.Listener
+	s         *Server
+	transport string
+}
+
+func (l *dripListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	dnsConnections.With(prometheus.Labels{"server": l.s.name, "transport": l.transport}).Inc()
+	return &dripConn{Conn: conn, s: l.s}, nil
+}
+
+// dripConn writes a reply one byte at a time when the server set a drip for the connection.
+type dripConn struct {
+	net.Conn
+	s *Server
+}
+
+func (c *dripConn) Write(b []byte) (int, error) {
+	delay, ok := c.s.drips.LoadAndDelete(c.RemoteAddr().String())
+	if !ok {
+		return c.Conn.Write(b)
+	}
+	for i := range b {
+		if i > 0 {
+			time.Sleep(delay.(time.Duration))
+		}
+		if _, err := c.Conn.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+	}
+	return len(b), nil
+}
@@ -0,0 +1,311 @@
+package dnsserver
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxSampledLatency caps the latency sampled from a model, so a long tail
+// can't hold an answer forever.
+const maxSampledLatency = 10 * time.Second
+
+// LatencyModel is the distribution the latency of each answer is sampled from.
+type LatencyModel interface {
+	// Sample returns the latency of a single answer.
+	Sample() time.Duration
+	// Median returns the median latency of the model.
+	Median() time.Duration
+	// WithMedian returns the model moved so its median is median, keeping its shape.
+	WithMedian(median time.Duration) LatencyModel
+	// String returns the model as ParseLatencyModel reads it.
+	String() string
+}
+
+// ParseLatencyModel reads a latency model from a spec like:
+//
+//	constant:20ms            always 20ms
+//	uniform:10ms:50ms        anything from 10ms to 50ms
+//	normal:30ms:5ms          mean 30ms, standard deviation 5ms
+//	lognormal:20ms:0.5       median 20ms, sigma 0.5
+//	pareto:10ms:1.5          at least 10ms, with a tail of shape 1.5
+//	empirical:rtts.txt       the latencies recorded in rtts.txt, one per line
+//	empirical:rtts.txt:20ms  the same, scaled so their median is 20ms
+func ParseLatencyModel(spec string) (LatencyModel, error) {
+	parts := strings.Split(spec, ":")
+	name, args := parts[0], parts[1:]
+	want := map[string]int{"constant": 1, "uniform": 2, "normal": 2, "lognormal": 2, "pareto": 2, "empirical": 1}
+	n, ok := want[name]
+	if !ok {
+		return nil, fmt.Errorf(`unknown latency model "%s"`, name)
+	}
+	if name == "empirical" && len(args) > 1 {
+		// the path may have colons in it, a trailing latency is the median to scale it to.
+		if median, err := parseLatency(args[len(args)-1]); err == nil {
+			e, err := LoadEmpirical(strings.Join(args[:len(args)-1], ":"))
+			if err != nil {
+				return nil, err
+			}
+			return e.WithMedian(median), nil
+		}
+		args = []string{strings.Join(args, ":")}
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf(`latency model "%s" takes %d arguments, got "%s"`, name, n, spec)
+	}
+
+	switch name {
+	case "constant":
+		d, err := parseLatency(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Constant(d), nil
+	case "uniform":
+		min, err := parseLatency(args[0])
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseLatency(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if max < min {
+			return nil, fmt.Errorf("uniform latency max %s is less than min %s", max, min)
+		}
+		return &Uniform{Min: min, Max: max}, nil
+	case "normal":
+		mean, err := parseLatency(args[0])
+		if err != nil {
+			return nil, err
+		}
+		stdDev, err := parseLatency(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Normal{Mean: mean, StdDev: stdDev}, nil
+	case "lognormal":
+		median, err := parseLatency(args[0])
+		if err != nil {
+			return nil, err
+		}
+		sigma, err := parsePositive(args[1])
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing sigma")
+		}
+		return &LogNormal{MedianLatency: median, Sigma: sigma}, nil
+	case "pareto":
+		min, err := parseLatency(args[0])
+		if err != nil {
+			return nil, err
+		}
+		alpha, err := parsePositive(args[1])
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing alpha")
+		}
+		return &Pareto{Min: min, Alpha: alpha}, nil
+	}
+	return LoadEmpirical(args[0])
+}
+
+func parseLatency(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, `parsing latency "%s"`, s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("latency can't be negative: %s", d)
+	}
+	return d, nil
+}
+
+func parsePositive(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf(`expected a number greater than 0, got "%s"`, s)
+	}
+	return f, nil
+}
+
+// capped keeps a sampled latency between 0 and maxSampledLatency.
+func capped(f float64) time.Duration {
+	if f < 0 {
+		return 0
+	}
+	if f > float64(maxSampledLatency) {
+		return maxSampledLatency
+	}
+	return time.Duration(f)
+}
+
+// Constant always takes the same time.
+type Constant time.Duration
+
+func (c Constant) Sample() time.Duration                        { return time.Duration(c) }
+func (c Constant) Median() time.Duration                        { return time.Duration(c) }
+func (c Constant) WithMedian(median time.Duration) LatencyModel { return Constant(median) }
+func (c Constant) String() string                               { return fmt.Sprintf("constant:%s", time.Duration(c)) }
+
+// Uniform takes anything from Min to Max, equally likely.
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+func (u *Uniform) Sample() time.Duration {
+	return u.Min + time.Duration(rand.Int63n(int64(u.Max-u.Min)+1))
+}
+
+func (u *Uniform) Median() time.Duration { return (u.Min + u.Max) / 2 }
+
+func (u *Uniform) WithMedian(median time.Duration) LatencyModel {
+	half := (u.Max - u.Min) / 2
+	if half > median {
+		half = median
+	}
+	return &Uniform{Min: median - half, Max: median + half}
+}
+
+func (u *Uniform) String() string { return fmt.Sprintf("uniform:%s:%s", u.Min, u.Max) }
+
+// Normal takes around Mean, StdDev apart; never less than 0.
+type Normal struct {
+	Mean, StdDev time.Duration
+}
+
+func (n *Normal) Sample() time.Duration {
+	return capped(float64(n.Mean) + rand.NormFloat64()*float64(n.StdDev))
+}
+
+func (n *Normal) Median() time.Duration { return n.Mean }
+
+func (n *Normal) WithMedian(median time.Duration) LatencyModel {
+	return &Normal{Mean: median, StdDev: n.StdDev}
+}
+
+func (n *Normal) String() string { return fmt.Sprintf("normal:%s:%s", n.Mean, n.StdDev) }
+
+// LogNormal takes around MedianLatency, with a long tail that grows with Sigma.
+type LogNormal struct {
+	MedianLatency time.Duration
+	Sigma         float64
+}
+
+func (l *LogNormal) Sample() time.Duration {
+	return capped(float64(l.MedianLatency) * math.Exp(l.Sigma*rand.NormFloat64()))
+}
+
+func (l *LogNormal) Median() time.Duration { return l.MedianLatency }
+
+func (l *LogNormal) WithMedian(median time.Duration) LatencyModel {
+	return &LogNormal{MedianLatency: median, Sigma: l.Sigma}
+}
+
+func (l *LogNormal) String() string { return fmt.Sprintf("lognormal:%s:%v", l.MedianLatency, l.Sigma) }
+
+// Pareto takes at least Min, with a heavy tail: the lower Alpha, the heavier.
+type Pareto struct {
+	Min   time.Duration
+	Alpha float64
+}
+
+func (p *Pareto) Sample() time.Duration {
+	// 1-Float64 is in (0, 1], so the division is safe.
+	return capped(float64(p.Min) / math.Pow(1-rand.Float64(), 1/p.Alpha))
+}
+
+func (p *Pareto) Median() time.Duration {
+	return time.Duration(float64(p.Min) * math.Pow(2, 1/p.Alpha))
+}
+
+func (p *Pareto) WithMedian(median time.Duration) LatencyModel {
+	return &Pareto{Min: time.Duration(float64(median) / math.Pow(2, 1/p.Alpha)), Alpha: p.Alpha}
+}
+
+func (p *Pareto) String() string { return fmt.Sprintf("pareto:%s:%v", p.Min, p.Alpha) }
+
+// Empirical takes one of a set of recorded latencies, at random.
+type Empirical struct {
+	// Path is the file the latencies were loaded from, for reference.
+	Path string
+	// Latencies are sorted.
+	Latencies []time.Duration
+	// Scaled is the median the latencies were scaled to, 0 if they weren't.
+	Scaled time.Duration
+}
+
+// LoadEmpirical reads the latencies recorded in the given file, one per line, either
+// as a duration like "12.5ms" or as a number of milliseconds. Empty lines and lines
+// starting with # are skipped.
+func LoadEmpirical(path string) (*Empirical, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `opening latencies file "%s"`, path)
+	}
+	defer f.Close()
+
+	e := &Empirical{Path: path}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			ms, msErr := strconv.ParseFloat(text, 64)
+			if msErr != nil {
+				return nil, errors.Wrapf(err, `reading latencies file "%s", line %d`, path, line)
+			}
+			d = time.Duration(ms * float64(time.Millisecond))
+		}
+		if d < 0 {
+			return nil, fmt.Errorf(`reading latencies file "%s", line %d: latency can't be negative: %s`, path, line, d)
+		}
+		e.Latencies = append(e.Latencies, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, `reading latencies file "%s"`, path)
+	}
+	if len(e.Latencies) == 0 {
+		return nil, fmt.Errorf(`latencies file "%s" has no latencies`, path)
+	}
+	sort.Slice(e.Latencies, func(i, j int) bool { return e.Latencies[i] < e.Latencies[j] })
+	return e, nil
+}
+
+func (e *Empirical) Sample() time.Duration {
+	return e.Latencies[rand.Intn(len(e.Latencies))]
+}
+
+func (e *Empirical) Median() time.Duration {
+	return e.Latencies[(len(e.Latencies)-1)/2]
+}
+
+// WithMedian scales all latencies, so the shape of the distribution is kept.
+func (e *Empirical) WithMedian(median time.Duration) LatencyModel {
+	scaled := &Empirical{Path: e.Path, Latencies: make([]time.Duration, len(e.Latencies)), Scaled: median}
+	current := e.Median()
+	for i, d := range e.Latencies {
+		if current == 0 {
+			scaled.Latencies[i] = median
+			continue
+		}
+		scaled.Latencies[i] = time.Duration(float64(d) * float64(median) / float64(current))
+	}
+	return scaled
+}
+
+func (e *Empirical) String() string {
+	if e.Scaled > 0 {
+		return fmt.Sprintf("empirical:%s:%s", e.Path, e.Scaled)
+	}
+	return fmt.Sprintf("empirical:%s", e.Path)
+}
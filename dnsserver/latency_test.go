@@ -0,0 +1,145 @@
+package dnsserver
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// sampledMedian returns the median of n samples of the model.
+func sampledMedian(m LatencyModel, n int) time.Duration {
+	samples := make([]time.Duration, n)
+	for i := range samples {
+		samples[i] = m.Sample()
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[n/2]
+}
+
+func TestParseLatencyModel(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rtts.txt")
+	if err := os.WriteFile(path, []byte("# recorded RTTs\n12ms\n\n30\n1.5ms\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "constant:20ms", want: "constant:20ms"},
+		{spec: "uniform:10ms:50ms", want: "uniform:10ms:50ms"},
+		{spec: "normal:30ms:5ms", want: "normal:30ms:5ms"},
+		{spec: "lognormal:20ms:0.5", want: "lognormal:20ms:0.5"},
+		{spec: "pareto:10ms:1.5", want: "pareto:10ms:1.5"},
+		{spec: "empirical:" + path, want: "empirical:" + path},
+		{spec: "empirical:" + path + ":24ms", want: "empirical:" + path + ":24ms"},
+		{spec: "exponential:10ms", wantErr: true},
+		{spec: "constant", wantErr: true},
+		{spec: "constant:-1ms", wantErr: true},
+		{spec: "constant:fast", wantErr: true},
+		{spec: "uniform:50ms:10ms", wantErr: true},
+		{spec: "lognormal:20ms:0", wantErr: true},
+		{spec: "pareto:10ms:-1", wantErr: true},
+		{spec: "empirical:" + filepath.Join(dir, "missing.txt"), wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			got, err := ParseLatencyModel(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got.String() != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadEmpirical(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rtts.txt")
+	if err := os.WriteFile(path, []byte("# recorded RTTs\n12ms\n\n30\n1.5ms\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := LoadEmpirical(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []time.Duration{1500 * time.Microsecond, 12 * time.Millisecond, 30 * time.Millisecond}
+	if len(e.Latencies) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, e.Latencies)
+	}
+	for i := range want {
+		if e.Latencies[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, e.Latencies)
+		}
+	}
+	if e.Median() != 12*time.Millisecond {
+		t.Errorf("Expected a median of 12ms, got %s", e.Median())
+	}
+
+	// once scaled, the spec says so, and reads back as the same latencies.
+	scaled := e.WithMedian(24 * time.Millisecond)
+	if expected := "empirical:" + path + ":24ms"; scaled.String() != expected {
+		t.Fatalf("Expected %s, got %s", expected, scaled)
+	}
+	parsed, err := ParseLatencyModel(scaled.String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want = []time.Duration{3 * time.Millisecond, 24 * time.Millisecond, 60 * time.Millisecond}
+	for i, d := range parsed.(*Empirical).Latencies {
+		if d != want[i] {
+			t.Errorf("Expected %v, got %v", want, parsed.(*Empirical).Latencies)
+		}
+	}
+}
+
+func TestLatencyModelsSampleAroundTheirMedian(t *testing.T) {
+	empirical := &Empirical{Latencies: []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond}}
+	models := []LatencyModel{
+		Constant(20 * time.Millisecond),
+		&Uniform{Min: 10 * time.Millisecond, Max: 30 * time.Millisecond},
+		&Normal{Mean: 20 * time.Millisecond, StdDev: 5 * time.Millisecond},
+		&LogNormal{MedianLatency: 20 * time.Millisecond, Sigma: 0.5},
+		&Pareto{Min: 10 * time.Millisecond, Alpha: 1.5},
+		empirical,
+	}
+	for _, m := range models {
+		t.Run(m.String(), func(t *testing.T) {
+			for _, median := range []time.Duration{m.Median(), 200 * time.Millisecond} {
+				moved := m.WithMedian(median)
+				if got := moved.Median(); absDiff(got, median) > median/100 {
+					t.Errorf("Expected a median of %s once moved, got %s", median, got)
+				}
+				if got := sampledMedian(moved, 20001); absDiff(got, median) > median/5 {
+					t.Errorf("Expected samples around a median of %s, got %s", median, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSampledLatencyIsCapped(t *testing.T) {
+	m := &Pareto{Min: time.Second, Alpha: 0.1}
+	for i := 0; i < 1000; i++ {
+		if d := m.Sample(); d < time.Second || d > maxSampledLatency {
+			t.Fatalf("Expected a latency from 1s to %s, got %s", maxSampledLatency, d)
+		}
+	}
+}
+
+func absDiff(a, b time.Duration) time.Duration {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
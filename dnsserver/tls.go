@@ -0,0 +1,195 @@
+package dnsserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Transports the server answers on, as labeled in its metrics.
+const (
+	UDP   = "udp"
+	TCP   = "tcp"
+	TLS   = "tls"
+	HTTPS = "https"
+)
+
+// certificateValidity is how long a generated certificate is valid for.
+const certificateValidity = 30 * 24 * time.Hour
+
+// DoHPath is where DNS over HTTPS queries are answered, as in RFC 8484.
+const DoHPath = "/dns-query"
+
+// dohContentType is the media type of DNS over HTTPS queries and answers.
+const dohContentType = "application/dns-message"
+
+// LoadOrCreateCertificate loads the certificate and key in the given PEM files, or, if they
+// don't exist yet or the certificate expired, generates a self-signed one for localhost
+// and 127.0.0.1 and saves it there. The certificate file doubles as the CA clients trust.
+func LoadOrCreateCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return tls.Certificate{}, errors.Wrapf(err, `parsing certificate "%s"`, certFile)
+		}
+		if time.Now().Before(leaf.NotAfter) {
+			return cert, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return tls.Certificate{}, errors.Wrapf(err, `loading certificate "%s"`, certFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "generating key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "generating serial number")
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ewma-policy-poc upstreams"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "creating certificate")
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "encoding key")
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, `writing certificate "%s"`, certFile)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, `writing key "%s"`, keyFile)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// dohHandler answers DNS over HTTPS queries, both GET and POST, with handler.
+func dohHandler(handler dns.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DoHPath, func(w http.ResponseWriter, r *http.Request) {
+		var buf []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			buf, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dohContentType {
+				http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			buf, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		m := new(dns.Msg)
+		if err == nil {
+			err = m.Unpack(buf)
+		}
+		if err != nil || len(m.Question) == 0 {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+		dw := &dohResponseWriter{w: w, r: r}
+		handler.ServeDNS(dw, m)
+		if !dw.written {
+			// a dropped query: keep the client waiting, as it would over UDP.
+			<-r.Context().Done()
+		}
+	})
+	return mux
+}
+
+// dohResponseWriter writes the answer to a DNS over HTTPS query.
+type dohResponseWriter struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	written bool
+	// delay, if set, is the time between the bytes of the answer.
+	delay time.Duration
+}
+
+func (d *dohResponseWriter) LocalAddr() net.Addr {
+	addr, _ := d.r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+func (d *dohResponseWriter) RemoteAddr() net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", d.r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+func (d *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = d.Write(buf)
+	return err
+}
+
+// drip makes the answer to this query, and no other on its connection, be written one
+// byte at a time.
+func (d *dohResponseWriter) drip(delay time.Duration) {
+	d.delay = delay
+}
+
+func (d *dohResponseWriter) Write(b []byte) (int, error) {
+	d.written = true
+	d.w.Header().Set("Content-Type", dohContentType)
+	if d.delay == 0 {
+		return d.w.Write(b)
+	}
+	flusher, _ := d.w.(http.Flusher)
+	for i := range b {
+		if i > 0 {
+			time.Sleep(d.delay)
+		}
+		if _, err := d.w.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return len(b), nil
+}
+
+func (d *dohResponseWriter) Close() error        { return nil }
+func (d *dohResponseWriter) TsigStatus() error   { return nil }
+func (d *dohResponseWriter) TsigTimersOnly(bool) {}
+func (d *dohResponseWriter) Hijack()             {}
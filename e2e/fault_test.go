@@ -0,0 +1,91 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tiagomelo/ewma-policy-poc/digger"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+)
+
+func setFault(t *testing.T, s *dnsserver.Server, f dnsserver.Fault) {
+	t.Helper()
+	if err := s.SetFault(f); err != nil {
+		t.Fatalf("setting %s fault: %v", f.Mode, err)
+	}
+}
+
+func TestBadQuestionFaultAnswersFormErr(t *testing.T) {
+	h := New(t, 1)
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.BadQuestion, Rate: 1})
+	h.StartCoreDNS("policy round_robin")
+
+	_, err := h.Query()
+	if rcode, _ := digger.Classify(err); rcode != dns.RcodeToString[dns.RcodeFormatError] {
+		t.Errorf("Expected FORMERR for a reply to another question, got %s: %v", rcode, err)
+	}
+}
+
+func TestBadIDFaultTimesOut(t *testing.T) {
+	h := New(t, 1)
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.BadID, Rate: 1})
+	h.StartCoreDNS("policy round_robin")
+
+	// CoreDNS skips the reply with another ID, and waits for the right one until the client gives up.
+	_, err := h.Query()
+	if rcode, outcome := digger.Classify(err); outcome != digger.Timeout {
+		t.Errorf("Expected a reply with another ID to time out, got %s %s: %v", rcode, outcome, err)
+	}
+}
+
+func TestServFailFaultIsPassedOn(t *testing.T) {
+	h := New(t, 1)
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.ServFail, Rate: 1})
+	h.StartCoreDNS("policy round_robin")
+
+	_, err := h.Query()
+	if rcode, outcome := digger.Classify(err); rcode != dns.RcodeToString[dns.RcodeServerFailure] || outcome != digger.Failure {
+		t.Errorf("Expected a SERVFAIL failure, got %s %s: %v", rcode, outcome, err)
+	}
+}
+
+func TestTruncateFaultIsRetriedOverTCP(t *testing.T) {
+	h := New(t, 1)
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.Truncate, Rate: 1})
+	h.StartCoreDNS("prefer_udp")
+
+	result, err := h.Query()
+	if err != nil {
+		t.Fatalf("Expected the truncated answer to be retried over TCP, got %v", err)
+	}
+	if result.Upstream != "server1" {
+		t.Errorf("Expected an answer from server1, got %q", result.Upstream)
+	}
+}
+
+func TestDripFaultSlowsTCPAnswers(t *testing.T) {
+	h := New(t, 1)
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.Drip, Rate: 1, Delay: 2 * time.Millisecond})
+	h.StartCoreDNS("force_tcp")
+
+	start := time.Now()
+	if _, err := h.Query(); err != nil {
+		t.Fatalf("Expected a dripped answer, got %v", err)
+	}
+	// the answer is well over 50 bytes, each one 2ms after the previous.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the dripped answer to take at least 100ms, took %s", elapsed)
+	}
+}
+
+func TestLatencyPolicyAvoidsDroppingUpstream(t *testing.T) {
+	h := startWithFastServer2(t)
+
+	setFault(t, h.Upstream("server2"), dnsserver.Fault{Mode: dnsserver.Drop, Rate: 1})
+	if !h.Eventually(20*time.Second, 20, func(tally *Tally) bool {
+		return tally.Failures() == 0 && tally.Share("server2") == 0
+	}) {
+		t.Error("Expected 20 queries in a row answered by the other upstreams within 20s of server2 dropping them")
+	}
+}
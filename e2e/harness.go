@@ -0,0 +1,309 @@
+// Package e2e is an end-to-end test harness for CoreDNS policies. It starts
+// DNS servers whose latency and availability the test controls, CoreDNS with
+// the policy under test in front of them, and tallies which server answered
+// each query.
+//
+//	h := e2e.New(t, 3)
+//	h.StartCoreDNS("policy latency")
+//	h.Upstream("server2").SetLatency(300 * time.Millisecond)
+//	ok := h.Eventually(5*time.Second, 20, func(t *e2e.Tally) bool {
+//		return t.Share("server2") <= 0.1
+//	})
+package e2e
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/digger"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+	"github.com/tiagomelo/ewma-policy-poc/instance"
+)
+
+const (
+	domain = "example.net"
+	// readyTimeout is how long a server has to start answering queries.
+	readyTimeout = 2 * time.Second
+)
+
+// Harness runs DNS servers and CoreDNS for a test, and stops them when the test ends.
+type Harness struct {
+	t         testing.TB
+	logger    *log.Logger
+	upstreams []*dnsserver.Server
+	coredns   *instance.Instance
+	digger    *digger.Digger
+	// certFile is the certificate the DNS servers answer DNS over TLS and HTTPS with.
+	certFile  string
+	tlsConfig *tls.Config
+}
+
+// New starts n DNS servers, named server1 to serverN, each with a latency of 1ms,
+// answering DNS over UDP, TCP, TLS and HTTPS.
+func New(t testing.TB, n int) *Harness {
+	t.Helper()
+	h := &Harness{t: t, logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+	h.certFile = filepath.Join(dir, "upstreams.crt")
+	cert, err := dnsserver.LoadOrCreateCertificate(h.certFile, filepath.Join(dir, "upstreams.key"))
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(mustParseCertificate(t, cert))
+	h.tlsConfig = &tls.Config{RootCAs: roots}
+	for i := 1; i <= n; i++ {
+		var ports [3]int
+		for j := range ports {
+			if ports[j], err = freePort(); err != nil {
+				t.Fatalf("finding a free port: %v", err)
+			}
+		}
+		s := dnsserver.NewServerWithLogger(fmt.Sprintf("server%d", i), ports[0], 1, h.logger)
+		s.SetTLS(cert, ports[1], ports[2])
+		h.upstreams = append(h.upstreams, s)
+		h.Start(s.GetName())
+	}
+	t.Cleanup(h.stop)
+	return h
+}
+
+func mustParseCertificate(t testing.TB, cert tls.Certificate) *x509.Certificate {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	return leaf
+}
+
+// freePort returns a port nobody listens on, neither with UDP nor with TCP.
+func freePort() (int, error) {
+	for {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		conn.Close()
+		if err == nil {
+			listener.Close()
+			return port, nil
+		}
+	}
+}
+
+func addr(s *dnsserver.Server) string {
+	return fmt.Sprintf("127.0.0.1:%d", s.GetPort())
+}
+
+// transportAddr returns the address the DNS server answers on over transport.
+func transportAddr(s *dnsserver.Server, transport string) string {
+	switch transport {
+	case digger.TLS:
+		return fmt.Sprintf("127.0.0.1:%d", s.GetTLSPort())
+	case digger.HTTPS:
+		return fmt.Sprintf("127.0.0.1:%d", s.GetHTTPSPort())
+	}
+	return addr(s)
+}
+
+// UpstreamDigger returns a digger querying the DNS server with the given name directly, over transport.
+func (h *Harness) UpstreamDigger(name, transport string) *digger.Digger {
+	h.t.Helper()
+	d, err := digger.NewWithTransport(h.logger, transportAddr(h.Upstream(name), transport), transport, h.tlsConfig)
+	if err != nil {
+		h.t.Fatalf("creating digger: %v", err)
+	}
+	return d
+}
+
+// Upstream returns the DNS server with the given name, to change its latency.
+func (h *Harness) Upstream(name string) *dnsserver.Server {
+	h.t.Helper()
+	for _, s := range h.upstreams {
+		if s.GetName() == name {
+			return s
+		}
+	}
+	h.t.Fatalf("unknown upstream %q", name)
+	return nil
+}
+
+// Stop stops the DNS server with the given name.
+func (h *Harness) Stop(name string) {
+	h.t.Helper()
+	if err := h.Upstream(name).Stop(); err != nil {
+		h.t.Fatalf("stopping %s: %v", name, err)
+	}
+}
+
+// Start starts the DNS server with the given name, and waits for it to answer queries.
+func (h *Harness) Start(name string) {
+	h.t.Helper()
+	s := h.Upstream(name)
+	s.Run()
+	d := digger.New(h.logger, addr(s))
+	deadline := time.Now().Add(readyTimeout)
+	for {
+		if _, err := d.Query(domain); err == nil {
+			return
+		} else if time.Now().After(deadline) {
+			h.t.Fatalf("%s didn't start answering queries: %v", name, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// StartCoreDNS starts CoreDNS forwarding to all DNS servers. forwardBlock is the
+// content of the block of the forward plugin, like "policy latency".
+func (h *Harness) StartCoreDNS(forwardBlock string) {
+	h.t.Helper()
+	upstreams := make([]string, len(h.upstreams))
+	for i, s := range h.upstreams {
+		upstreams[i] = addr(s)
+	}
+	h.startCoreDNS(upstreams, forwardBlock)
+}
+
+// StartCoreDNSOverTLS starts CoreDNS forwarding to all DNS servers over TLS, like StartCoreDNS.
+func (h *Harness) StartCoreDNSOverTLS(forwardBlock string) {
+	h.t.Helper()
+	upstreams := make([]string, len(h.upstreams))
+	for i, s := range h.upstreams {
+		upstreams[i] = "tls://" + transportAddr(s, digger.TLS)
+	}
+	h.startCoreDNS(upstreams, fmt.Sprintf("tls %s\n        %s", h.certFile, forwardBlock))
+}
+
+func (h *Harness) startCoreDNS(upstreams []string, forwardBlock string) {
+	h.t.Helper()
+	corefile := fmt.Sprintf(".:0 {\n    forward . %s {\n        %s\n    }\n}\n", strings.Join(upstreams, " "), forwardBlock)
+	coredns, err := instance.Start(corefile, log.New(io.Discard, "", 0))
+	if err != nil {
+		h.t.Fatalf("starting coredns: %v", err)
+	}
+	h.coredns = coredns
+	h.digger = digger.New(h.logger, coredns.Addr())
+}
+
+func (h *Harness) stop() {
+	if h.coredns != nil {
+		h.coredns.Stop()
+	}
+	for _, s := range h.upstreams {
+		if s.IsRunning() {
+			s.Stop()
+		}
+	}
+}
+
+// Query sends a single query to CoreDNS.
+func (h *Harness) Query() (*digger.Result, error) {
+	h.t.Helper()
+	if h.digger == nil {
+		h.t.Fatal("coredns is not started")
+	}
+	return h.digger.Query(domain)
+}
+
+// Drive sends n queries to CoreDNS, one after the other, and tallies them.
+func (h *Harness) Drive(n int) *Tally {
+	h.t.Helper()
+	tally := newTally()
+	for i := 0; i < n; i++ {
+		tally.add(h.Query())
+	}
+	return tally
+}
+
+// Eventually sends queries to CoreDNS, one after the other, until cond holds for the
+// last window queries, or timeout expires. It returns whether cond held in time.
+func (h *Harness) Eventually(timeout time.Duration, window int, cond func(*Tally) bool) bool {
+	h.t.Helper()
+	type outcome struct {
+		result *digger.Result
+		err    error
+	}
+	var last []outcome
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		result, err := h.Query()
+		last = append(last, outcome{result, err})
+		if len(last) < window {
+			continue
+		}
+		last = last[len(last)-window:]
+		tally := newTally()
+		for _, o := range last {
+			tally.add(o.result, o.err)
+		}
+		if cond(tally) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tally counts the queries each DNS server answered.
+type Tally struct {
+	mux       sync.Mutex
+	total     int
+	failures  int
+	upstreams map[string]int
+}
+
+func newTally() *Tally {
+	return &Tally{upstreams: make(map[string]int)}
+}
+
+func (t *Tally) add(result *digger.Result, err error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.total++
+	if err != nil {
+		t.failures++
+		return
+	}
+	t.upstreams[result.Upstream]++
+}
+
+// Total returns the number of queries sent.
+func (t *Tally) Total() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.total
+}
+
+// Failures returns the number of queries that failed.
+func (t *Tally) Failures() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.failures
+}
+
+// Share returns the fraction of the queries the DNS server with the given name answered.
+func (t *Tally) Share(name string) float64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.total == 0 {
+		return 0
+	}
+	return float64(t.upstreams[name]) / float64(t.total)
+}
+
+func (t *Tally) String() string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return fmt.Sprintf("%d queries, %d failures, answered by %v", t.total, t.failures, t.upstreams)
+}
@@ -0,0 +1,113 @@
+package e2e
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+)
+
+// exploringLatency is the latency policy, exploring often enough to find the fastest upstream quickly.
+const exploringLatency = "policy latency {\n explore 10%\n }"
+
+// startWithFastServer2 starts 3 upstreams where server2 is the fastest, and CoreDNS with
+// the latency policy, once it sends most queries to server2.
+func startWithFastServer2(t *testing.T) *Harness {
+	return startWithFastest(t, 3, "server2")
+}
+
+// startWithFastest starts n upstreams where fastest is the fastest one, and CoreDNS with
+// the latency policy, once it sends most queries to fastest.
+func startWithFastest(t *testing.T, n int, fastest string) *Harness {
+	h := New(t, n)
+	for i := 1; i <= n; i++ {
+		h.Upstream(fmt.Sprintf("server%d", i)).SetLatency(30 * time.Millisecond)
+	}
+	h.Upstream(fastest).SetLatency(5 * time.Millisecond)
+	h.StartCoreDNS(exploringLatency)
+
+	if !h.Eventually(5*time.Second, 20, func(tally *Tally) bool {
+		return tally.Share(fastest) >= 0.8
+	}) {
+		t.Fatalf("Expected the fastest upstream, %s, to answer most queries within 5s", fastest)
+	}
+	return h
+}
+
+func TestLatencyPolicyPrefersFastestUpstream(t *testing.T) {
+	startWithFastServer2(t)
+}
+
+func TestLatencyPolicyMovesAwayFromSlowUpstream(t *testing.T) {
+	h := startWithFastServer2(t)
+
+	h.Upstream("server2").SetLatency(300 * time.Millisecond)
+	if !h.Eventually(5*time.Second, 20, func(tally *Tally) bool {
+		return tally.Share("server2") <= 0.1
+	}) {
+		t.Error("Expected at least 90% of the queries to go elsewhere within 5s of server2 getting slow")
+	}
+}
+
+func TestLatencyPolicyWithTwoUpstreams(t *testing.T) {
+	h := startWithFastest(t, 2, "server2")
+
+	h.Upstream("server2").SetLatency(300 * time.Millisecond)
+	if !h.Eventually(5*time.Second, 20, func(tally *Tally) bool {
+		return tally.Share("server1") >= 0.8
+	}) {
+		t.Error("Expected server1 to answer most queries within 5s of server2 getting slow")
+	}
+}
+
+func TestLatencyPolicyWithManyUpstreams(t *testing.T) {
+	h := startWithFastest(t, 12, "server7")
+
+	h.Upstream("server7").SetLatency(300 * time.Millisecond)
+	h.Upstream("server11").SetLatency(5 * time.Millisecond)
+	if !h.Eventually(10*time.Second, 20, func(tally *Tally) bool {
+		return tally.Share("server11") >= 0.8
+	}) {
+		t.Error("Expected the new fastest upstream, server11, to answer most queries within 10s")
+	}
+}
+
+func TestLatencyPolicyPrefersLowerMedianLatency(t *testing.T) {
+	h := New(t, 3)
+	h.Upstream("server1").SetLatencyModel(&dnsserver.LogNormal{MedianLatency: 40 * time.Millisecond, Sigma: 0.5})
+	h.Upstream("server2").SetLatencyModel(&dnsserver.LogNormal{MedianLatency: 5 * time.Millisecond, Sigma: 0.5})
+	h.Upstream("server3").SetLatencyModel(&dnsserver.Normal{Mean: 40 * time.Millisecond, StdDev: 10 * time.Millisecond})
+	h.StartCoreDNS(exploringLatency)
+
+	if !h.Eventually(5*time.Second, 20, func(tally *Tally) bool {
+		return tally.Share("server2") >= 0.8
+	}) {
+		t.Error("Expected server2, with the lowest median latency, to answer most queries within 5s")
+	}
+}
+
+func TestLatencyPolicyAvoidsStoppedUpstream(t *testing.T) {
+	h := startWithFastServer2(t)
+
+	h.Stop("server2")
+	tally := h.Drive(20)
+	if tally.Failures() > 0 {
+		t.Errorf("Expected no failed queries while the other upstreams are up, got %s", tally)
+	}
+	if tally.Share("server2") > 0 {
+		t.Errorf("Expected no answers from the stopped upstream, got %s", tally)
+	}
+}
+
+func TestRoundRobinPolicySpreadsQueries(t *testing.T) {
+	h := New(t, 3)
+	h.StartCoreDNS("policy round_robin")
+
+	tally := h.Drive(30)
+	for _, name := range []string{"server1", "server2", "server3"} {
+		if share := tally.Share(name); share < 0.3 || share > 0.37 {
+			t.Errorf("Expected %s to answer a third of the queries, got %s", name, tally)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/digger"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+)
+
+func TestUpstreamsAnswerOverEveryTransport(t *testing.T) {
+	h := New(t, 1)
+	for _, transport := range digger.Transports {
+		t.Run(transport, func(t *testing.T) {
+			result, err := h.UpstreamDigger("server1", transport).Query(domain)
+			if err != nil {
+				t.Fatalf("Expected an answer over %s, got %v", transport, err)
+			}
+			if result.Upstream != "server1" {
+				t.Errorf("Expected an answer from server1, got %q", result.Upstream)
+			}
+		})
+	}
+}
+
+func TestDropFaultTimesOutOverHTTPS(t *testing.T) {
+	h := New(t, 1)
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.Drop, Rate: 1})
+
+	_, err := h.UpstreamDigger("server1", digger.HTTPS).Query(domain)
+	if _, outcome := digger.Classify(err); outcome != digger.Timeout {
+		t.Errorf("Expected a dropped query over HTTPS to time out, got %s: %v", outcome, err)
+	}
+}
+
+func TestDripFaultSlowsOnlyTheDrippedAnswerOverHTTPS(t *testing.T) {
+	h := New(t, 1)
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.Drip, Rate: 1, Delay: 2 * time.Millisecond})
+	d := h.UpstreamDigger("server1", digger.HTTPS)
+
+	start := time.Now()
+	if _, err := d.Query(domain); err != nil {
+		t.Fatalf("Expected a dripped answer over HTTPS, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the dripped answer to take at least 100ms, took %s", elapsed)
+	}
+
+	// the next answer, on the same connection, isn't dripped.
+	setFault(t, h.Upstream("server1"), dnsserver.Fault{Mode: dnsserver.Drip})
+	start = time.Now()
+	if _, err := d.Query(domain); err != nil {
+		t.Fatalf("Expected an answer over HTTPS, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected the answer after the drip to be fast, took %s", elapsed)
+	}
+}
+
+func TestLatencyPolicyOverTLS(t *testing.T) {
+	h := New(t, 3)
+	h.Upstream("server1").SetLatency(30 * time.Millisecond)
+	h.Upstream("server3").SetLatency(30 * time.Millisecond)
+	h.Upstream("server2").SetLatency(5 * time.Millisecond)
+	h.StartCoreDNSOverTLS(exploringLatency)
+
+	if !h.Eventually(5*time.Second, 20, func(tally *Tally) bool {
+		return tally.Share("server2") >= 0.8
+	}) {
+		t.Fatal("Expected the fastest upstream, server2, to answer most queries over TLS within 5s")
+	}
+
+	h.Upstream("server2").SetLatency(300 * time.Millisecond)
+	if !h.Eventually(5*time.Second, 20, func(tally *Tally) bool {
+		return tally.Share("server2") <= 0.1
+	}) {
+		t.Error("Expected at least 90% of the queries to go elsewhere within 5s of server2 getting slow over TLS")
+	}
+}
@@ -0,0 +1,111 @@
+// Package histogram records durations in an HDR-style histogram: buckets are
+// linear within each power of two and exponential across them, so any value
+// from a microsecond to hours is kept with at most 1/64 (about 1.6%) error, in
+// a fixed amount of memory, no matter how many values are recorded.
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// unit is the smallest duration told apart.
+	unit = time.Microsecond
+	// subBucketBits is the number of significant bits kept of each value: each
+	// power of two is split in halfBuckets linear buckets.
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+	halfBuckets   = subBuckets / 2
+	// numBuckets is enough for any non-negative int64.
+	numBuckets = (64-subBucketBits+1)*halfBuckets + halfBuckets
+)
+
+// Histogram is a histogram of durations, safe for concurrent use.
+type Histogram struct {
+	mux    sync.Mutex
+	counts [numBuckets]int64
+	total  int64
+	max    time.Duration
+}
+
+// New creates a new, empty Histogram.
+func New() *Histogram {
+	return &Histogram{}
+}
+
+// Record adds d to the histogram. Negative durations count as zero.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := index(uint64(d / unit))
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.counts[i]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Count returns how many durations were recorded.
+func (h *Histogram) Count() int64 {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.total
+}
+
+// Max returns the longest duration recorded, exactly.
+func (h *Histogram) Max() time.Duration {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.max
+}
+
+// Percentile returns the duration p percent of the recorded durations are at
+// most, as the highest value of its bucket. It returns 0 if nothing was recorded.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			if d := time.Duration(highest(i)) * unit; d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// index returns the bucket of v: values below subBuckets have a bucket each,
+// larger ones share a bucket with the values equal in their top subBucketBits bits.
+func index(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	return shift*halfBuckets + int(v>>shift)
+}
+
+// highest returns the highest value that falls in bucket i.
+func highest(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := i/halfBuckets - 1
+	sub := uint64(i - shift*halfBuckets)
+	return (sub+1)<<shift - 1
+}
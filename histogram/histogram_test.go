@@ -0,0 +1,59 @@
+package histogram
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestIndexAndHighest(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 129, 255, 256, 1000, 123456, 1 << 40, 1<<63 - 1} {
+		i := index(v)
+		if i >= numBuckets {
+			t.Fatalf("Expected bucket of %d below %d, got %d", v, numBuckets, i)
+		}
+		if h := highest(i); h < v {
+			t.Errorf("Expected the highest value of bucket %d to be at least %d, got %d", i, v, h)
+		}
+		if i > 0 && highest(i-1) >= v {
+			t.Errorf("Expected %d above the previous bucket, which ends at %d", v, highest(i-1))
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	h := New()
+	if p := h.Percentile(50); p != 0 {
+		t.Errorf("Expected 0 on an empty histogram, got %s", p)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	values := make([]time.Duration, 10000)
+	for i := range values {
+		values[i] = time.Duration(r.ExpFloat64() * float64(20*time.Millisecond))
+		h.Record(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, tc := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, values[4999]},
+		{95, values[9499]},
+		{99, values[9899]},
+		{100, values[9999]},
+	} {
+		got := h.Percentile(tc.p)
+		if got < tc.want || float64(got-tc.want) > float64(tc.want)/halfBuckets+float64(unit) {
+			t.Errorf("Expected p%v within 1/64 above %s, got %s", tc.p, tc.want, got)
+		}
+	}
+	if h.Max() != values[9999] {
+		t.Errorf("Expected max %s, got %s", values[9999], h.Max())
+	}
+	if h.Count() != 10000 {
+		t.Errorf("Expected 10000 values, got %d", h.Count())
+	}
+}
@@ -0,0 +1,84 @@
+// Package instance runs CoreDNS inside the tester process, so a whole
+// experiment is a single command, and it can run from Go tests as well.
+package instance
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/pkg/errors"
+
+	// Plug in the CoreDNS plugins the Corefiles in conf/ use.
+	_ "github.com/coredns/coredns/plugin/errors"
+	_ "github.com/coredns/coredns/plugin/forward"
+	_ "github.com/coredns/coredns/plugin/grpc"
+	_ "github.com/coredns/coredns/plugin/log"
+	_ "github.com/coredns/coredns/plugin/metrics"
+)
+
+// caddy's settings are global, so instances are started one at a time.
+var mux sync.Mutex
+
+// Instance is a CoreDNS server running in the tester process.
+type Instance struct {
+	caddy *caddy.Instance
+}
+
+// input implements caddy.Input, it is a Corefile in a string.
+type input struct {
+	corefile string
+}
+
+func (i input) Body() []byte       { return []byte(i.corefile) }
+func (i input) Path() string       { return "Corefile" }
+func (i input) ServerType() string { return "dns" }
+
+// Start starts CoreDNS with the given Corefile contents. CoreDNS logs, like the ones
+// of the log plugin, go to logger. They aren't kept apart per instance, so the logger
+// of the last instance started gets the logs of all of them.
+func Start(corefile string, logger *log.Logger) (*Instance, error) {
+	mux.Lock()
+	defer mux.Unlock()
+	caddy.Quiet = true
+	dnsserver.Quiet = true
+	clog.SetLogger(logger)
+
+	i, err := caddy.Start(input{corefile})
+	if err != nil {
+		return nil, errors.Wrap(err, "starting coredns")
+	}
+	return &Instance{caddy: i}, nil
+}
+
+// StartFile starts CoreDNS with the Corefile at path, after passing it through adapt.
+// adapt may be nil, to use the Corefile as is.
+func StartFile(path string, adapt func(string) string, logger *log.Logger) (*Instance, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `reading corefile "%s"`, path)
+	}
+	corefile := string(b)
+	if adapt != nil {
+		corefile = adapt(corefile)
+	}
+	return Start(corefile, logger)
+}
+
+// Addr returns the UDP address the first server block listens on. It is handy
+// when the Corefile asks for port 0.
+func (i *Instance) Addr() string {
+	servers := i.caddy.Servers()
+	if len(servers) == 0 || servers[0].LocalAddr() == nil {
+		return ""
+	}
+	return servers[0].LocalAddr().String()
+}
+
+// Stop stops CoreDNS.
+func (i *Instance) Stop() error {
+	return i.caddy.Stop()
+}
@@ -0,0 +1,170 @@
+// Package load generates DNS requests in an open loop: each request has an
+// intended send time, fixed by the load profile only, and is sent then no matter how
+// many earlier requests are still waiting for an answer. Latency is measured
+// from the intended send time, so a stall shows up as latency instead of
+// silently lowering the rate (coordinated omission).
+package load
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tiagomelo/ewma-policy-poc/screen/stats"
+)
+
+// lateTolerance is how far behind its intended send time a request may be sent
+// before it counts as late.
+const lateTolerance = time.Millisecond
+
+// rateInterval is how often the target and achieved rates are updated.
+const rateInterval = time.Second
+
+var (
+	lateDnsRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "late_dns_requests_total",
+			Help: "Number of DNS requests sent later than intended.",
+		},
+	)
+	droppedDnsRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dropped_dns_requests_total",
+			Help: "Number of DNS requests not sent because too many were in flight.",
+		},
+	)
+	targetDnsRequestsPerSecond = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "target_dns_requests_per_second",
+			Help: "Rate of DNS requests the load profile asks for.",
+		},
+	)
+	achievedDnsRequestsPerSecond = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "achieved_dns_requests_per_second",
+			Help: "Rate of DNS requests actually sent over the last second.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(lateDnsRequests)
+	prometheus.MustRegister(droppedDnsRequests)
+	prometheus.MustRegister(targetDnsRequestsPerSecond)
+	prometheus.MustRegister(achievedDnsRequestsPerSecond)
+}
+
+// Sender must be implemented by types that send the generated requests.
+type Sender interface {
+	// Send sends a request that was intended to be sent at intended.
+	Send(ctx context.Context, intended time.Time)
+}
+
+// Limit tells when to stop generating requests. Zero values mean no limit.
+type Limit struct {
+	Requests int
+	Duration time.Duration
+}
+
+// Generator sends requests at the rate of a load profile.
+type Generator struct {
+	profile     Profile
+	maxInFlight int
+	stats       *stats.Statistics
+	// sent is the number of requests sent so far, to work out the achieved rate.
+	sent int64
+}
+
+// New creates a new Generator sending requests as profile says, with at most
+// maxInFlight of them waiting for an answer at any time.
+func New(profile Profile, maxInFlight int, stats *stats.Statistics) *Generator {
+	return &Generator{
+		profile:     profile,
+		maxInFlight: maxInFlight,
+		stats:       stats,
+	}
+}
+
+// Run sends requests through s until limit is reached or ctx is done, and then
+// waits for the requests in flight.
+func (g *Generator) Run(ctx context.Context, s Sender, limit Limit) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	inFlight := make(chan struct{}, g.maxInFlight)
+
+	start := time.Now()
+	rateCtx, stopRates := context.WithCancel(ctx)
+	defer stopRates()
+	go g.updateRates(rateCtx, start)
+
+	var elapsed time.Duration
+	for i := 0; limit.Requests == 0 || i < limit.Requests; i, elapsed = i+1, elapsed+g.profile.Gap(elapsed) {
+		if limit.Duration != 0 && elapsed >= limit.Duration {
+			return
+		}
+		intended := start.Add(elapsed)
+
+		// wait for the intended send time, if we aren't behind already.
+		if wait := time.Until(intended); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		g.stats.IncrTotalDnsRequests()
+		if time.Since(intended) > lateTolerance {
+			g.stats.IncrTotalLateDnsRequests()
+			lateDnsRequests.Inc()
+		}
+		select {
+		case inFlight <- struct{}{}:
+			atomic.AddInt64(&g.sent, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Send(ctx, intended)
+				<-inFlight
+			}()
+		default:
+			g.stats.IncrTotalDroppedDnsRequests()
+			droppedDnsRequests.Inc()
+		}
+	}
+}
+
+// updateRates publishes the target rate of the profile and the achieved rate
+// every rateInterval, until ctx is done.
+func (g *Generator) updateRates(ctx context.Context, start time.Time) {
+	g.setTargetRate(g.profile.Rate(0))
+
+	ticker := time.NewTicker(rateInterval)
+	defer ticker.Stop()
+	last, lastSent := start, int64(0)
+	for {
+		select {
+		case now := <-ticker.C:
+			sent := atomic.LoadInt64(&g.sent)
+			achieved := float64(sent-lastSent) / now.Sub(last).Seconds()
+			g.stats.SetAchievedRequestsPerSecond(achieved)
+			achievedDnsRequestsPerSecond.Set(achieved)
+			g.setTargetRate(g.profile.Rate(now.Sub(start)))
+			last, lastSent = now, sent
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (g *Generator) setTargetRate(rate float64) {
+	g.stats.SetTargetRequestsPerSecond(rate)
+	targetDnsRequestsPerSecond.Set(rate)
+}
@@ -0,0 +1,139 @@
+package load
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/screen/stats"
+)
+
+// countingSender counts the requests it's asked to send, and blocks each one until
+// release is closed, if it's set.
+type countingSender struct {
+	sent    int64
+	release chan struct{}
+}
+
+func (s *countingSender) Send(ctx context.Context, intended time.Time) {
+	atomic.AddInt64(&s.sent, 1)
+	if s.release != nil {
+		<-s.release
+	}
+}
+
+// stallingProfile is a constant profile whose generator stalls for stall after every request,
+// as if it was paused by the garbage collector.
+type stallingProfile struct {
+	constant
+	stall time.Duration
+}
+
+func (p stallingProfile) Gap(elapsed time.Duration) time.Duration {
+	time.Sleep(p.stall)
+	return p.constant.Gap(elapsed)
+}
+
+func TestRunStopsAtLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    Limit
+		expected int64
+	}{
+		{"requests", Limit{Requests: 50}, 50},
+		// a request every 5ms, the one at 100ms isn't sent anymore.
+		{"duration", Limit{Duration: 100 * time.Millisecond}, 20},
+		{"requests first", Limit{Requests: 10, Duration: time.Second}, 10},
+		{"duration first", Limit{Requests: 1000, Duration: 50 * time.Millisecond}, 10},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := new(countingSender)
+			st := stats.New()
+			New(constant(200), 100, st).Run(context.Background(), s, tc.limit)
+			if s.sent != tc.expected {
+				t.Errorf("Expected %d requests to be sent, got %d", tc.expected, s.sent)
+			}
+			if x := st.TotalDnsRequests(); x != tc.expected {
+				t.Errorf("Expected %d requests to be counted, got %d", tc.expected, x)
+			}
+			if x := st.TotalDroppedDnsRequests(); x != 0 {
+				t.Errorf("Expected no request to be dropped, got %d", x)
+			}
+		})
+	}
+}
+
+func TestRunStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s := new(countingSender)
+	start := time.Now()
+	New(constant(100), 100, stats.New()).Run(ctx, s, Limit{})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Run to return once the context is done, it took %s", elapsed)
+	}
+	if s.sent == 0 || s.sent > 6 {
+		t.Errorf("Expected about 5 requests to be sent in 50ms, got %d", s.sent)
+	}
+}
+
+func TestRunDropsWhenTooManyAreInFlight(t *testing.T) {
+	s := &countingSender{release: make(chan struct{})}
+	st := stats.New()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		New(constant(1000), 2, st).Run(context.Background(), s, Limit{Requests: 10})
+	}()
+
+	// the 10 requests take 10ms to generate, the first 2 never answer until then.
+	time.Sleep(100 * time.Millisecond)
+	close(s.release)
+	wg.Wait()
+
+	if s.sent != 2 {
+		t.Errorf("Expected only 2 requests to be sent, got %d", s.sent)
+	}
+	if x := st.TotalDroppedDnsRequests(); x != 8 {
+		t.Errorf("Expected 8 requests to be dropped, got %d", x)
+	}
+	if x := st.TotalDnsRequests(); x != 10 {
+		t.Errorf("Expected the dropped requests to be counted too, got %d", x)
+	}
+}
+
+func TestRunCountsLateRequests(t *testing.T) {
+	s := new(countingSender)
+	st := stats.New()
+	// requests are due every millisecond, but the generator stalls for 5ms after each one.
+	profile := stallingProfile{constant: constant(1000), stall: 5 * time.Millisecond}
+	New(profile, 100, st).Run(context.Background(), s, Limit{Requests: 5})
+
+	// the first request is on time, the others are sent as soon as the generator is back.
+	if x := st.TotalLateDnsRequests(); x != 4 {
+		t.Errorf("Expected 4 late requests, got %d", x)
+	}
+	if s.sent != 5 {
+		t.Errorf("Expected late requests to be sent anyway, got %d sent", s.sent)
+	}
+}
+
+func TestRunAchievedRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes more than a second")
+	}
+	st := stats.New()
+	New(constant(500), 100, st).Run(context.Background(), new(countingSender), Limit{Duration: 1200 * time.Millisecond})
+
+	if x := st.TargetRequestsPerSecond(); x != 500 {
+		t.Errorf("Expected a target rate of 500/s, got %v", x)
+	}
+	if x := st.AchievedRequestsPerSecond(); math.Abs(x-500) > 50 {
+		t.Errorf("Expected an achieved rate of about 500/s, got %v", x)
+	}
+}
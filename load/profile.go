@@ -0,0 +1,204 @@
+package load
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Profiles that can be chosen by name.
+const (
+	Constant = "constant"
+	Ramp     = "ramp"
+	Step     = "step"
+	Sine     = "sine"
+	Burst    = "burst"
+)
+
+// Profile is the shape of the traffic over time.
+type Profile interface {
+	// Rate returns the target rate, in requests per second, elapsed since the start.
+	Rate(elapsed time.Duration) float64
+	// Gap returns the time between the request sent elapsed since the start and the next one.
+	Gap(elapsed time.Duration) time.Duration
+}
+
+// ProfileConfig holds the settings of all profiles, each profile uses the ones it needs.
+type ProfileConfig struct {
+	Name string
+	// Rate is the constant rate, and the rate the other profiles start at.
+	Rate float64
+	// PeakRate is the rate a ramp goes to, the highest rate of a sine and the rate of the bursts.
+	PeakRate float64
+	// Period is how long a ramp takes, how long each step lasts, the period of a sine
+	// and how often a burst starts.
+	Period time.Duration
+	// Steps are the rates a step profile goes through after Rate, in order.
+	Steps []float64
+	// BurstDuration is how long a burst lasts.
+	BurstDuration time.Duration
+	// Seed seeds the random arrivals of a burst profile.
+	Seed int64
+}
+
+// NewProfile returns the profile the config describes.
+func NewProfile(cfg ProfileConfig) (Profile, error) {
+	switch cfg.Name {
+	case "", Constant, Ramp, Step, Sine, Burst:
+	default:
+		return nil, fmt.Errorf(`unknown profile "%s"`, cfg.Name)
+	}
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("rate must be greater than 0: %v", cfg.Rate)
+	}
+	if cfg.Name == Constant || cfg.Name == "" {
+		return constant(cfg.Rate), nil
+	}
+	if cfg.Period <= 0 {
+		return nil, fmt.Errorf("period of %s profile must be greater than 0: %s", cfg.Name, cfg.Period)
+	}
+	if cfg.Name == Step {
+		if len(cfg.Steps) == 0 {
+			return nil, errors.New("step profile needs at least one step")
+		}
+		for _, rate := range cfg.Steps {
+			if rate <= 0 {
+				return nil, fmt.Errorf("steps must be greater than 0: %v", rate)
+			}
+		}
+		steps := append([]float64{cfg.Rate}, cfg.Steps...)
+		return &step{steps: steps, period: cfg.Period}, nil
+	}
+	if cfg.PeakRate <= 0 {
+		return nil, fmt.Errorf("peak rate of %s profile must be greater than 0: %v", cfg.Name, cfg.PeakRate)
+	}
+	switch cfg.Name {
+	case Ramp:
+		return &ramp{from: cfg.Rate, to: cfg.PeakRate, over: cfg.Period}, nil
+	case Sine:
+		return &sine{low: cfg.Rate, high: cfg.PeakRate, period: cfg.Period}, nil
+	}
+	if cfg.BurstDuration <= 0 || cfg.BurstDuration > cfg.Period {
+		return nil, fmt.Errorf("burst duration must be greater than 0 and at most the period: %s", cfg.BurstDuration)
+	}
+	return &burst{
+		rate:     cfg.Rate,
+		peak:     cfg.PeakRate,
+		period:   cfg.Period,
+		duration: cfg.BurstDuration,
+		r:        rand.New(rand.NewSource(cfg.Seed)),
+	}, nil
+}
+
+// Adjustable is a Profile whose rates can be scaled while requests are being sent.
+type Adjustable struct {
+	profile Profile
+	// factor is stored as the bits of a float64, so it can be changed atomically.
+	factor uint64
+}
+
+// NewAdjustable returns profile, at its own rates until they are scaled.
+func NewAdjustable(profile Profile) *Adjustable {
+	return &Adjustable{profile: profile, factor: math.Float64bits(1)}
+}
+
+// Factor returns what the rates of the profile are multiplied by.
+func (a *Adjustable) Factor() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&a.factor))
+}
+
+// SetFactor multiplies the rates of the profile by factor, which must be greater than 0.
+func (a *Adjustable) SetFactor(factor float64) error {
+	if !(factor > 0) || math.IsInf(factor, 1) {
+		return fmt.Errorf("rate factor must be greater than 0: %v", factor)
+	}
+	atomic.StoreUint64(&a.factor, math.Float64bits(factor))
+	return nil
+}
+
+func (a *Adjustable) Rate(elapsed time.Duration) float64 {
+	return a.profile.Rate(elapsed) * a.Factor()
+}
+
+func (a *Adjustable) Gap(elapsed time.Duration) time.Duration {
+	return time.Duration(float64(a.profile.Gap(elapsed)) / a.Factor())
+}
+
+// evenly returns the gap between requests sent evenly at rate.
+func evenly(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// constant sends requests at a fixed rate.
+type constant float64
+
+func (c constant) Rate(time.Duration) float64      { return float64(c) }
+func (c constant) Gap(time.Duration) time.Duration { return evenly(float64(c)) }
+
+// ramp changes the rate linearly from one rate to another over a period, and keeps it there.
+type ramp struct {
+	from, to float64
+	over     time.Duration
+}
+
+func (r *ramp) Rate(elapsed time.Duration) float64 {
+	if elapsed >= r.over {
+		return r.to
+	}
+	return r.from + (r.to-r.from)*float64(elapsed)/float64(r.over)
+}
+
+func (r *ramp) Gap(elapsed time.Duration) time.Duration { return evenly(r.Rate(elapsed)) }
+
+// step goes through a list of rates, each one for a period, and keeps the last one.
+type step struct {
+	steps  []float64
+	period time.Duration
+}
+
+func (s *step) Rate(elapsed time.Duration) float64 {
+	i := int(elapsed / s.period)
+	if i >= len(s.steps) {
+		i = len(s.steps) - 1
+	}
+	return s.steps[i]
+}
+
+func (s *step) Gap(elapsed time.Duration) time.Duration { return evenly(s.Rate(elapsed)) }
+
+// sine moves the rate between a low and a high rate, starting at the low one.
+type sine struct {
+	low, high float64
+	period    time.Duration
+}
+
+func (s *sine) Rate(elapsed time.Duration) float64 {
+	phase := 2 * math.Pi * float64(elapsed) / float64(s.period)
+	return s.low + (s.high-s.low)*(1-math.Cos(phase))/2
+}
+
+func (s *sine) Gap(elapsed time.Duration) time.Duration { return evenly(s.Rate(elapsed)) }
+
+// burst sends requests as a Poisson process, at a base rate with bursts at a peak rate
+// at the end of every period.
+type burst struct {
+	rate, peak       float64
+	period, duration time.Duration
+	r                *rand.Rand
+}
+
+func (b *burst) Rate(elapsed time.Duration) float64 {
+	if elapsed%b.period >= b.period-b.duration {
+		return b.peak
+	}
+	return b.rate
+}
+
+// Gap returns exponentially distributed gaps, so arrivals are random but average the rate.
+func (b *burst) Gap(elapsed time.Duration) time.Duration {
+	return time.Duration(b.r.ExpFloat64() / b.Rate(elapsed) * float64(time.Second))
+}
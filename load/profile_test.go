@@ -0,0 +1,129 @@
+package load
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewProfileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ProfileConfig
+		wantErr string
+	}{
+		{"unknown profile", ProfileConfig{Name: "square", Rate: 10}, `unknown profile "square"`},
+		{"no rate", ProfileConfig{Name: Constant}, "rate must be greater than 0"},
+		{"negative rate", ProfileConfig{Name: Ramp, Rate: -1, PeakRate: 10, Period: time.Second}, "rate must be greater than 0"},
+		{"no period", ProfileConfig{Name: Ramp, Rate: 10, PeakRate: 100}, "period of ramp profile must be greater than 0"},
+		{"no steps", ProfileConfig{Name: Step, Rate: 10, Period: time.Second}, "needs at least one step"},
+		{"zero step", ProfileConfig{Name: Step, Rate: 10, Period: time.Second, Steps: []float64{20, 0}}, "steps must be greater than 0"},
+		{"no peak rate", ProfileConfig{Name: Sine, Rate: 10, Period: time.Second}, "peak rate of sine profile must be greater than 0"},
+		{"no burst duration", ProfileConfig{Name: Burst, Rate: 10, PeakRate: 100, Period: time.Second}, "burst duration"},
+		{"burst longer than period", ProfileConfig{Name: Burst, Rate: 10, PeakRate: 100, Period: time.Second, BurstDuration: 2 * time.Second}, "burst duration"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewProfile(tc.cfg)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v (profile %v)", tc.wantErr, err, p)
+			}
+		})
+	}
+}
+
+func TestProfileRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      ProfileConfig
+		elapsed  time.Duration
+		expected float64
+	}{
+		{"constant", ProfileConfig{Rate: 50}, time.Hour, 50},
+		{"ramp start", ProfileConfig{Name: Ramp, Rate: 10, PeakRate: 110, Period: 10 * time.Second}, 0, 10},
+		{"ramp middle", ProfileConfig{Name: Ramp, Rate: 10, PeakRate: 110, Period: 10 * time.Second}, 5 * time.Second, 60},
+		{"ramp end", ProfileConfig{Name: Ramp, Rate: 10, PeakRate: 110, Period: 10 * time.Second}, 10 * time.Second, 110},
+		{"ramp holds at peak", ProfileConfig{Name: Ramp, Rate: 10, PeakRate: 110, Period: 10 * time.Second}, time.Minute, 110},
+		{"step first", ProfileConfig{Name: Step, Rate: 10, Period: 10 * time.Second, Steps: []float64{20, 40}}, 9 * time.Second, 10},
+		{"step second", ProfileConfig{Name: Step, Rate: 10, Period: 10 * time.Second, Steps: []float64{20, 40}}, 10 * time.Second, 20},
+		{"step last", ProfileConfig{Name: Step, Rate: 10, Period: 10 * time.Second, Steps: []float64{20, 40}}, 25 * time.Second, 40},
+		{"step keeps last", ProfileConfig{Name: Step, Rate: 10, Period: 10 * time.Second, Steps: []float64{20, 40}}, time.Hour, 40},
+		{"sine low", ProfileConfig{Name: Sine, Rate: 10, PeakRate: 30, Period: 10 * time.Second}, 0, 10},
+		{"sine middle", ProfileConfig{Name: Sine, Rate: 10, PeakRate: 30, Period: 10 * time.Second}, 2500 * time.Millisecond, 20},
+		{"sine high", ProfileConfig{Name: Sine, Rate: 10, PeakRate: 30, Period: 10 * time.Second}, 5 * time.Second, 30},
+		{"sine low again", ProfileConfig{Name: Sine, Rate: 10, PeakRate: 30, Period: 10 * time.Second}, 10 * time.Second, 10},
+		{"burst base", ProfileConfig{Name: Burst, Rate: 10, PeakRate: 100, Period: 10 * time.Second, BurstDuration: 2 * time.Second}, 7 * time.Second, 10},
+		{"burst peak", ProfileConfig{Name: Burst, Rate: 10, PeakRate: 100, Period: 10 * time.Second, BurstDuration: 2 * time.Second}, 8 * time.Second, 100},
+		{"burst base next period", ProfileConfig{Name: Burst, Rate: 10, PeakRate: 100, Period: 10 * time.Second, BurstDuration: 2 * time.Second}, 10 * time.Second, 10},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewProfile(tc.cfg)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if x := p.Rate(tc.elapsed); math.Abs(x-tc.expected) > 1e-9 {
+				t.Errorf("Expected a rate of %v at %s, got %v", tc.expected, tc.elapsed, x)
+			}
+		})
+	}
+}
+
+func TestProfileGapIsEven(t *testing.T) {
+	p, err := NewProfile(ProfileConfig{Name: Ramp, Rate: 10, PeakRate: 100, Period: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := p.Gap(0); x != 100*time.Millisecond {
+		t.Errorf("Expected a gap of 100ms at 10/s, got %s", x)
+	}
+	if x := p.Gap(time.Minute); x != 10*time.Millisecond {
+		t.Errorf("Expected a gap of 10ms at 100/s, got %s", x)
+	}
+}
+
+func TestBurstGaps(t *testing.T) {
+	cfg := ProfileConfig{Name: Burst, Rate: 100, PeakRate: 1000, Period: time.Minute, BurstDuration: time.Second, Seed: 42}
+	first, err := NewProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := NewProfile(cfg)
+
+	const n = 20000
+	var total time.Duration
+	for i := 0; i < n; i++ {
+		gap := first.Gap(0)
+		if x := second.Gap(0); x != gap {
+			t.Fatalf("Expected gap %d to be %s with the same seed, got %s", i, gap, x)
+		}
+		total += gap
+	}
+	// the gaps are random, but average the rate of 100/s.
+	if mean := total / n; mean < 9500*time.Microsecond || mean > 10500*time.Microsecond {
+		t.Errorf("Expected the gaps to average 10ms, got %s", mean)
+	}
+}
+
+func TestAdjustable(t *testing.T) {
+	a := NewAdjustable(constant(100))
+	if a.Factor() != 1 || a.Rate(0) != 100 || a.Gap(0) != 10*time.Millisecond {
+		t.Fatalf("Expected the rates of the profile until scaled, got x%v, %v/s, %s", a.Factor(), a.Rate(0), a.Gap(0))
+	}
+	if err := a.SetFactor(2); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if a.Rate(0) != 200 || a.Gap(0) != 5*time.Millisecond {
+		t.Errorf("Expected the rate to double, got %v/s and a gap of %s", a.Rate(0), a.Gap(0))
+	}
+
+	for _, factor := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if err := a.SetFactor(factor); err == nil {
+			t.Errorf("Expected an error for a factor of %v", factor)
+		}
+	}
+	if a.Factor() != 2 {
+		t.Errorf("Expected a rejected factor to leave the factor at 2, got %v", a.Factor())
+	}
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"net"
 	"os"
+	"path/filepath"
 	"text/template"
 
 	"github.com/pkg/errors"
@@ -22,6 +23,42 @@ func ParseWithLocalIpAddr(serverPort int, templateFile, outputFile string) error
 		IP:   ip,
 		Port: serverPort,
 	}
+	return parse(templateFile, outputFile, data)
+}
+
+// CorefileData is what the Corefile templates are rendered with.
+type CorefileData struct {
+	// Port is the port CoreDNS listens on.
+	Port int
+	// Upstreams are the addresses of the DNS servers to forward to.
+	Upstreams []string
+	// TLSUpstreams are the DNS over TLS addresses of the DNS servers, like "tls://127.0.0.1:9051".
+	TLSUpstreams []string
+	// CertFile is the certificate of the DNS servers, for CoreDNS to trust over TLS.
+	// It is relative to the root of the repo, where CoreDNS runs from to read it.
+	CertFile string
+}
+
+// ParseCorefiles renders every Corefile template in templateDir into a file
+// with the same name in outputDir, with the given data.
+func ParseCorefiles(data *CorefileData, templateDir, outputDir string) error {
+	templateFiles, err := filepath.Glob(filepath.Join(templateDir, "*Corefile"))
+	if err != nil {
+		return errors.Wrapf(err, `listing templates in "%s"`, templateDir)
+	}
+	if len(templateFiles) == 0 {
+		return errors.Errorf(`no Corefile templates in "%s"`, templateDir)
+	}
+	for _, templateFile := range templateFiles {
+		outputFile := filepath.Join(outputDir, filepath.Base(templateFile))
+		if err := parse(templateFile, outputFile, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parse(templateFile, outputFile string, data interface{}) error {
 	tmpl, err := template.ParseFiles(templateFile)
 	if err != nil {
 		return errors.Wrapf(err, `parsing template file "%s"`, templateFile)
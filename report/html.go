@@ -0,0 +1,153 @@
+package report
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+)
+
+// Here we are embedding the page template
+var (
+	//go:embed report.html
+	page string
+
+	pageTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+		"percentage": func(f float64) string { return fmt.Sprintf("%.1f%%", 100*f) },
+		"describe":   describe,
+	}).Parse(page))
+)
+
+// chart dimensions, in pixels.
+const (
+	chartWidth  = 900
+	chartHeight = 320
+	chartLeft   = 50
+	chartRight  = 20
+	chartTop    = 20
+	chartBottom = 40
+)
+
+// palette is the colors of the series of a chart, in order.
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+// eventColors is the color of the marker of each chaos action.
+var eventColors = map[chaos.Action]string{
+	chaos.SetLatency:  "#ff7f0e",
+	chaos.Stop:        "#d62728",
+	chaos.Start:       "#2ca02c",
+	chaos.InjectFault: "#9467bd",
+	chaos.SetModel:    "#8c564b",
+}
+
+type legendEntry struct {
+	Name  string
+	Color string
+}
+
+// pageData is what the page template is rendered with.
+type pageData struct {
+	*Report
+	OptionsJSON string
+	ConfigJSON  string
+	Chart       template.HTML
+	Legend      []legendEntry
+}
+
+func (r *Report) writeHTML(w io.Writer) error {
+	options, err := json.MarshalIndent(r.Options, "", "  ")
+	if err != nil {
+		return err
+	}
+	config, err := json.MarshalIndent(r.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	names := series(r.Shares)
+	legend := make([]legendEntry, len(names))
+	for i, name := range names {
+		legend[i] = legendEntry{Name: name, Color: palette[i%len(palette)]}
+	}
+	return pageTemplate.Execute(w, pageData{
+		Report:      r,
+		OptionsJSON: string(options),
+		ConfigJSON:  string(config),
+		Chart:       shareChart(r.Shares, r.Events, time.Duration(r.Duration), legend),
+		Legend:      legend,
+	})
+}
+
+// shareChart draws the share of each upstream over time as an SVG line chart,
+// with a marker for each chaos event.
+func shareChart(shares []Share, events []chaos.Event, duration time.Duration, legend []legendEntry) template.HTML {
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	if duration <= 0 {
+		duration = time.Second
+	}
+	x := func(at chaos.Duration) float64 {
+		f := float64(at) / float64(duration)
+		if f > 1 {
+			f = 1
+		}
+		return chartLeft + f*plotWidth
+	}
+	y := func(share float64) float64 {
+		return chartTop + (1-share)*plotHeight
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`, chartWidth, chartHeight)
+
+	// axes, with a grid line every 25%, and the time at the start, middle and end.
+	for _, share := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, chartLeft, y(share), chartWidth-chartRight, y(share))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%.0f%%</text>`, chartLeft-5, y(share)+4, 100*share)
+	}
+	for _, f := range []float64{0, 0.5, 1} {
+		at := chaos.Duration(time.Duration(f * float64(duration)).Round(time.Second))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, x(at), chartHeight-chartBottom+15, time.Duration(at))
+	}
+
+	for _, e := range events {
+		title := template.HTMLEscapeString(fmt.Sprintf("%s: %s %s", e.At, e.Server, describe(e)))
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="%s" stroke-dasharray="3,3"><title>%s</title></line>`,
+			x(e.At), chartTop, x(e.At), chartHeight-chartBottom, eventColors[e.Action], title)
+	}
+
+	for _, entry := range legend {
+		points := make([]string, len(shares))
+		for i, s := range shares {
+			points[i] = fmt.Sprintf("%.1f,%.1f", x(s.At), y(s.Shares[entry.Name]))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"><title>%s</title></polyline>`,
+			entry.Color, strings.Join(points, " "), template.HTMLEscapeString(entry.Name))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// describe returns what a chaos event does to its server.
+func describe(e chaos.Event) string {
+	switch e.Action {
+	case chaos.SetLatency:
+		return fmt.Sprintf("latency %s", e.Latency)
+	case chaos.InjectFault:
+		if e.Rate == 0 {
+			return fmt.Sprintf("%s fault cleared", e.Fault)
+		}
+		if e.Delay > 0 {
+			return fmt.Sprintf("%s fault on %.0f%% of the queries, %s", e.Fault, 100*e.Rate, e.Delay)
+		}
+		return fmt.Sprintf("%s fault on %.0f%% of the queries", e.Fault, 100*e.Rate)
+	case chaos.SetModel:
+		return fmt.Sprintf("latency model %s", e.Model)
+	}
+	return string(e.Action)
+}
@@ -0,0 +1,69 @@
+// Package report saves a summary of a run when it ends: as JSON, to track
+// trends across runs in CI, and as an HTML page with charts, to read.
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+)
+
+// Report is the summary of a run.
+type Report struct {
+	// Options are the command line options of the run.
+	Options interface{} `json:"options"`
+	// Config is the configuration read from the environment.
+	Config interface{} `json:"config"`
+	// Policies are the CoreDNS policies under test, more than one when comparing them.
+	// They are empty when CoreDNS runs outside the tester.
+	Policies []string       `json:"policies,omitempty"`
+	Seed     int64          `json:"seed"`
+	Start    time.Time      `json:"start"`
+	Duration chaos.Duration `json:"duration"`
+
+	Queries        int64 `json:"queries"`
+	FailedQueries  int64 `json:"failed_queries"`
+	LateQueries    int64 `json:"late_queries"`
+	DroppedQueries int64 `json:"dropped_queries"`
+
+	Latency Latency `json:"latency"`
+	// Comparison is the side-by-side table of the compared policies, the first row is the header.
+	Comparison [][]string `json:"comparison,omitempty"`
+	// Shares is the share of the answers given by each upstream over time.
+	Shares []Share `json:"shares"`
+	// Events is the timeline of the chaos applied to the upstreams.
+	Events []chaos.Event `json:"events"`
+}
+
+// Latency holds the percentiles of the client-side latency of the queries.
+type Latency struct {
+	P50 chaos.Duration `json:"p50"`
+	P95 chaos.Duration `json:"p95"`
+	P99 chaos.Duration `json:"p99"`
+	Max chaos.Duration `json:"max"`
+}
+
+// Save writes the report to path with a .json extension, and as a page with charts
+// to path with an .html extension.
+func (r *Report) Save(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding report")
+	}
+	if err := os.WriteFile(path+".json", b, 0644); err != nil {
+		return errors.Wrapf(err, `writing report file "%s.json"`, path)
+	}
+
+	f, err := os.Create(path + ".html")
+	if err != nil {
+		return errors.Wrapf(err, `creating report file "%s.html"`, path)
+	}
+	defer f.Close()
+	if err := r.writeHTML(f); err != nil {
+		return errors.Wrapf(err, `writing report file "%s.html"`, path)
+	}
+	return nil
+}
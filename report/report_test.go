@@ -0,0 +1,166 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+)
+
+func TestTimelineShares(t *testing.T) {
+	start := time.Now()
+	tl := NewTimeline(start, time.Second)
+	tl.Add(start.Add(-time.Millisecond), "server1")
+	tl.Add(start.Add(100*time.Millisecond), "server1")
+	tl.Add(start.Add(200*time.Millisecond), "server2")
+	tl.Add(start.Add(900*time.Millisecond), "server1")
+	// nothing in the second interval, one unknown answer in the third.
+	tl.Add(start.Add(2500*time.Millisecond), "")
+
+	shares := tl.Shares()
+	if len(shares) != 3 {
+		t.Fatalf("Expected 3 intervals, got %d: %+v", len(shares), shares)
+	}
+	if shares[0].Answers["server1"] != 3 || shares[0].Answers["server2"] != 1 {
+		t.Errorf("Expected 3 answers of server1 and 1 of server2, got %v", shares[0].Answers)
+	}
+	if shares[0].Shares["server1"] != 0.75 || shares[0].Shares["server2"] != 0.25 {
+		t.Errorf("Expected shares of 75%% and 25%%, got %v", shares[0].Shares)
+	}
+	if len(shares[1].Answers) != 0 || shares[1].At != chaos.Duration(time.Second) {
+		t.Errorf("Expected an empty interval at 1s, got %+v", shares[1])
+	}
+	if shares[2].Shares[Unknown] != 1 {
+		t.Errorf("Expected the answer without an upstream to be %s, got %v", Unknown, shares[2].Shares)
+	}
+	if names := series(shares); strings.Join(names, ",") != "server1,server2,unknown" {
+		t.Errorf("Expected the series server1, server2 and unknown, got %v", names)
+	}
+}
+
+func TestTimelineSharesPerPolicy(t *testing.T) {
+	start := time.Now()
+	tl := NewTimeline(start, time.Second)
+	tl.Add(start, "latency/server1")
+	tl.Add(start, "latency/server1")
+	tl.Add(start, "latency/server1")
+	tl.Add(start, "latency/server2")
+	tl.Add(start, "random/server2")
+	tl.Add(start, "random/"+Unknown)
+
+	expected := map[string]float64{
+		"latency/server1": 0.75,
+		"latency/server2": 0.25,
+		"random/server2":  0.5,
+		"random/unknown":  0.5,
+	}
+	shares := tl.Shares()
+	if len(shares) != 1 {
+		t.Fatalf("Expected 1 interval, got %d: %+v", len(shares), shares)
+	}
+	for upstream, share := range expected {
+		if x := shares[0].Shares[upstream]; x != share {
+			t.Errorf("Expected a share of %v for %s, got %v", share, upstream, x)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		event    chaos.Event
+		expected string
+	}{
+		{chaos.Event{Action: chaos.Stop}, "stop"},
+		{chaos.Event{Action: chaos.Start}, "start"},
+		{chaos.Event{Action: chaos.SetLatency, Latency: chaos.Duration(200 * time.Millisecond)}, "latency 200ms"},
+		{chaos.Event{Action: chaos.SetModel, Model: "lognormal:20ms:0.5"}, "latency model lognormal:20ms:0.5"},
+		{chaos.Event{Action: chaos.InjectFault, Fault: "servfail", Rate: 0.25}, "servfail fault on 25% of the queries"},
+		{chaos.Event{Action: chaos.InjectFault, Fault: "spike", Rate: 0.5, Delay: chaos.Duration(300 * time.Millisecond)}, "spike fault on 50% of the queries, 300ms"},
+		{chaos.Event{Action: chaos.InjectFault, Fault: "spike"}, "spike fault cleared"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expected, func(t *testing.T) {
+			if x := describe(tc.event); x != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, x)
+			}
+		})
+	}
+}
+
+func TestSave(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tl := NewTimeline(start, time.Second)
+	tl.Add(start, "server1")
+	tl.Add(start.Add(1500*time.Millisecond), "server2")
+	r := &Report{
+		Options:  map[string]interface{}{"duration": "2s"},
+		Config:   map[string]interface{}{"DNS_SERVERS": "server1=10ms"},
+		Policies: []string{"ewma", "p2c_ewma"},
+		Seed:     42,
+		Start:    start,
+		Duration: chaos.Duration(2 * time.Second),
+		Queries:  2,
+		Latency:  Latency{P50: chaos.Duration(10 * time.Millisecond), Max: chaos.Duration(30 * time.Millisecond)},
+		Comparison: [][]string{
+			{"policy", "p50"},
+			{"ewma", "10ms"},
+			{"p2c_ewma", "<12ms>"},
+		},
+		Shares: tl.Shares(),
+		Events: []chaos.Event{{At: chaos.Duration(time.Second), Server: "server1", Action: chaos.SetLatency, Latency: chaos.Duration(200 * time.Millisecond)}},
+	}
+	path := filepath.Join(t.TempDir(), "report")
+	if err := r.Save(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	b, err := os.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Report
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatalf("Expected the report to be valid JSON, got %v", err)
+	}
+	if saved.Seed != 42 || saved.Duration != r.Duration || len(saved.Shares) != 2 || len(saved.Events) != 1 || saved.Events[0] != r.Events[0] {
+		t.Errorf("Expected the saved report to read back the same, got %+v", saved)
+	}
+
+	b, err = os.ReadFile(path + ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	for _, expected := range []string{
+		"<td>ewma, p2c_ewma</td>",
+		"<svg ",
+		"<title>1s: server1 latency 200ms</title>",
+		"<td>latency 200ms</td>",
+		"<td>100.0%</td>",
+		"<th>server2</th>",
+		// cells of the comparison are escaped.
+		"<td>&lt;12ms&gt;</td>",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected the page to contain %q", expected)
+		}
+	}
+}
+
+func TestSaveWithoutPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report")
+	if err := (&Report{}).Save(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	b, err := os.ReadFile(path + ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "CoreDNS ran outside the tester") || !strings.Contains(string(b), "<p>None.</p>") {
+		t.Error("Expected the page to say the policies are unknown and there were no chaos events")
+	}
+}
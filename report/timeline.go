@@ -0,0 +1,106 @@
+package report
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+)
+
+// Unknown is the series of answers that didn't say which upstream they came from.
+const Unknown = "unknown"
+
+// Timeline counts the answers of each upstream in fixed intervals since the start of a run.
+// It is safe for concurrent use.
+type Timeline struct {
+	mux      sync.Mutex
+	start    time.Time
+	interval time.Duration
+	counts   []map[string]int
+}
+
+// NewTimeline creates a new Timeline for a run started at start, counting answers every interval.
+func NewTimeline(start time.Time, interval time.Duration) *Timeline {
+	return &Timeline{start: start, interval: interval}
+}
+
+// Add counts an answer of upstream at the given time. Use Unknown when the upstream isn't known.
+func (t *Timeline) Add(at time.Time, upstream string) {
+	if upstream == "" {
+		upstream = Unknown
+	}
+	i := int(at.Sub(t.start) / t.interval)
+	if i < 0 {
+		i = 0
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	for len(t.counts) <= i {
+		t.counts = append(t.counts, make(map[string]int))
+	}
+	t.counts[i][upstream]++
+}
+
+// Share is how the answers of an interval of the run were spread across the upstreams.
+type Share struct {
+	// At is when the interval starts, since the start of the run.
+	At      chaos.Duration `json:"at"`
+	Answers map[string]int `json:"answers"`
+	// Shares are the fractions of the answers of the interval given by each upstream.
+	// Upstreams counted as "policy/upstream" are fractions of the answers of their policy.
+	Shares map[string]float64 `json:"shares"`
+}
+
+// Shares returns the share of each upstream in every interval so far.
+// When comparing policies, the shares of the upstreams of each policy add up to 1.
+func (t *Timeline) Shares() []Share {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	shares := make([]Share, len(t.counts))
+	for i, counts := range t.counts {
+		totals := make(map[string]int)
+		for upstream, n := range counts {
+			totals[policy(upstream)] += n
+		}
+		share := Share{
+			At:      chaos.Duration(time.Duration(i) * t.interval),
+			Answers: make(map[string]int, len(counts)),
+			Shares:  make(map[string]float64, len(counts)),
+		}
+		for upstream, n := range counts {
+			share.Answers[upstream] = n
+			share.Shares[upstream] = float64(n) / float64(totals[policy(upstream)])
+		}
+		shares[i] = share
+	}
+	return shares
+}
+
+// policy returns the policy of an upstream counted as "policy/upstream",
+// or "" when it isn't.
+func policy(upstream string) string {
+	if i := strings.LastIndex(upstream, "/"); i >= 0 {
+		return upstream[:i]
+	}
+	return ""
+}
+
+// series returns the names of all upstreams in shares, sorted.
+func series(shares []Share) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, s := range shares {
+		for name := range s.Answers {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
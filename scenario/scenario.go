@@ -0,0 +1,244 @@
+// Package scenario reads declarative chaos timelines from YAML or JSON files,
+// so a test, or a regression, can be written down and kept in the repo.
+//
+// A scenario is a list of steps, each applied to a DNS server at a given
+// offset from the start of the test:
+//
+//	name: slow server2, then server1 goes away
+//	steps:
+//	  - at: 30s
+//	    server: server2
+//	    action: latency
+//	    latency: 200ms
+//	  - at: 60s
+//	    server: server1
+//	    action: stop
+//	    for: 20s
+//	  - at: 90s
+//	    server: server3
+//	    action: ramp
+//	    from: 10ms
+//	    to: 500ms
+//	    over: 30s
+//	  - at: 120s
+//	    server: server2
+//	    action: fault
+//	    mode: servfail
+//	    rate: 0.3
+//	    for: 30s
+//	  - at: 150s
+//	    server: server1
+//	    action: model
+//	    model: lognormal:20ms:0.8
+package scenario
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+	"gopkg.in/yaml.v3"
+)
+
+// Actions a step can take.
+const (
+	// Latency sets the latency of a server.
+	Latency = "latency"
+	// Stop stops a server, for a while if For is set.
+	Stop = "stop"
+	// Start starts a stopped server again.
+	Start = "start"
+	// Ramp changes the latency of a server linearly From one value To another Over a period.
+	Ramp = "ramp"
+	// Fault makes a server misbehave in some Mode on a Rate of the queries, for a while if For is set.
+	Fault = "fault"
+	// Model sets the latency model of a server, see dnsserver.ParseLatencyModel.
+	Model = "model"
+)
+
+// defaultRampEvery is how often a ramp changes the latency, unless told otherwise.
+const defaultRampEvery = time.Second
+
+// Scenario is a chaos timeline.
+type Scenario struct {
+	Name  string `yaml:"name"`
+	Steps []Step `yaml:"steps"`
+}
+
+// Step is a single change to a DNS server.
+type Step struct {
+	At     time.Duration `yaml:"at"`
+	Server string        `yaml:"server"`
+	Action string        `yaml:"action"`
+
+	// Latency is the new latency of a latency step.
+	Latency time.Duration `yaml:"latency"`
+	// For is how long a stop or fault step lasts, the server is started again,
+	// or the fault cleared, afterwards. 0 means it lasts until another step.
+	For time.Duration `yaml:"for"`
+	// From, To and Over describe a ramp step, which changes the latency Every so often.
+	From  time.Duration `yaml:"from"`
+	To    time.Duration `yaml:"to"`
+	Over  time.Duration `yaml:"over"`
+	Every time.Duration `yaml:"every"`
+	// Mode, Rate and Delay describe the fault of a fault step, see dnsserver.Fault.
+	Mode  string        `yaml:"mode"`
+	Rate  float64       `yaml:"rate"`
+	Delay time.Duration `yaml:"delay"`
+	// Model is the latency model of a model step.
+	Model string `yaml:"model"`
+}
+
+// Load reads and validates the scenario in the given file. Both YAML and JSON files are accepted,
+// servers are the names of the DNS servers the scenario may refer to.
+func Load(path string, servers []string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `reading scenario file "%s"`, path)
+	}
+	s := new(Scenario)
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(s); err != nil {
+		return nil, errors.Wrapf(err, `parsing scenario file "%s"`, path)
+	}
+	if err := s.Validate(servers); err != nil {
+		return nil, errors.Wrapf(err, `validating scenario file "%s"`, path)
+	}
+	return s, nil
+}
+
+// Validate checks the steps of the scenario only refer to the given servers and make sense.
+func (s *Scenario) Validate(servers []string) error {
+	if len(s.Steps) == 0 {
+		return errors.New("scenario has no steps")
+	}
+	known := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		known[server] = true
+	}
+	for i, step := range s.Steps {
+		if err := step.validate(known); err != nil {
+			return errors.Wrapf(err, "step %d", i+1)
+		}
+	}
+	return nil
+}
+
+func (st Step) validate(known map[string]bool) error {
+	if st.At < 0 {
+		return fmt.Errorf("at can't be negative: %s", st.At)
+	}
+	if !known[st.Server] {
+		return fmt.Errorf(`unknown server "%s"`, st.Server)
+	}
+	switch st.Action {
+	case Latency:
+		if st.Latency < 0 {
+			return fmt.Errorf("latency can't be negative: %s", st.Latency)
+		}
+	case Stop:
+		if st.For < 0 {
+			return fmt.Errorf("for can't be negative: %s", st.For)
+		}
+	case Start:
+	case Ramp:
+		if st.From < 0 || st.To < 0 {
+			return fmt.Errorf("ramp latencies can't be negative: %s to %s", st.From, st.To)
+		}
+		if st.Over <= 0 {
+			return fmt.Errorf("ramp must last more than 0s: %s", st.Over)
+		}
+		if st.Every < 0 {
+			return fmt.Errorf("every can't be negative: %s", st.Every)
+		}
+	case Fault:
+		if st.For < 0 {
+			return fmt.Errorf("for can't be negative: %s", st.For)
+		}
+		if err := st.fault().Validate(); err != nil {
+			return err
+		}
+	case Model:
+		if _, err := dnsserver.ParseLatencyModel(st.Model); err != nil {
+			return err
+		}
+	case "":
+		return errors.New("missing action")
+	default:
+		return fmt.Errorf(`unknown action "%s"`, st.Action)
+	}
+	return nil
+}
+
+// Events expands the steps of the scenario into chaos events, in chronological order.
+func (s *Scenario) Events() []chaos.Event {
+	var events []chaos.Event
+	for _, st := range s.Steps {
+		events = append(events, st.events()...)
+	}
+	// steps don't have to be in order, but events at the same time keep the order they are written in.
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At < events[j].At
+	})
+	return events
+}
+
+func (st Step) events() []chaos.Event {
+	event := func(at time.Duration, action chaos.Action, latency time.Duration) chaos.Event {
+		return chaos.Event{At: chaos.Duration(at), Server: st.Server, Action: action, Latency: chaos.Duration(latency)}
+	}
+	switch st.Action {
+	case Latency:
+		return []chaos.Event{event(st.At, chaos.SetLatency, st.Latency)}
+	case Stop:
+		events := []chaos.Event{event(st.At, chaos.Stop, 0)}
+		if st.For > 0 {
+			events = append(events, event(st.At+st.For, chaos.Start, 0))
+		}
+		return events
+	case Start:
+		return []chaos.Event{event(st.At, chaos.Start, 0)}
+	case Ramp:
+		every := st.Every
+		if every == 0 {
+			every = defaultRampEvery
+		}
+		var events []chaos.Event
+		for elapsed := time.Duration(0); elapsed < st.Over; elapsed += every {
+			latency := st.From + time.Duration(float64(st.To-st.From)*float64(elapsed)/float64(st.Over))
+			events = append(events, event(st.At+elapsed, chaos.SetLatency, latency))
+		}
+		return append(events, event(st.At+st.Over, chaos.SetLatency, st.To))
+	case Fault:
+		inject := event(st.At, chaos.InjectFault, 0)
+		inject.Fault, inject.Rate, inject.Delay = st.Mode, st.Rate, chaos.Duration(st.Delay)
+		events := []chaos.Event{inject}
+		if st.For > 0 {
+			clear := event(st.At+st.For, chaos.InjectFault, 0)
+			clear.Fault = st.Mode
+			events = append(events, clear)
+		}
+		return events
+	case Model:
+		model := event(st.At, chaos.SetModel, 0)
+		model.Model = st.Model
+		return []chaos.Event{model}
+	}
+	return nil
+}
+
+func (st Step) fault() dnsserver.Fault {
+	return dnsserver.Fault{Mode: dnsserver.FaultMode(st.Mode), Rate: st.Rate, Delay: st.Delay}
+}
+
+// Source returns a chaos.Source that yields the events of the scenario.
+func (s *Scenario) Source() chaos.Source {
+	schedule := &chaos.Schedule{Events: s.Events()}
+	return schedule.Replay()
+}
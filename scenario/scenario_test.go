@@ -0,0 +1,223 @@
+package scenario
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+)
+
+var testServers = []string{"server1", "server2", "server3"}
+
+// load writes content to a file with the given name and loads it as a scenario.
+func load(t *testing.T, name, content string) (*Scenario, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path, testServers)
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			file: "scenario.yaml",
+			content: `name: slow server2
+steps:
+  - at: 30s
+    server: server2
+    action: latency
+    latency: 200ms
+`,
+		},
+		{
+			name:    "json",
+			file:    "scenario.json",
+			content: `{"name": "slow server2", "steps": [{"at": "30s", "server": "server2", "action": "latency", "latency": "200ms"}]}`,
+		},
+		{
+			name:    "unknown field",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 30s\n    server: server2\n    action: latency\n    latncy: 200ms\n",
+			wantErr: "field latncy not found",
+		},
+		{
+			name:    "no steps",
+			file:    "scenario.yaml",
+			content: "name: empty\n",
+			wantErr: "scenario has no steps",
+		},
+		{
+			name:    "unknown server",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 30s\n    server: server9\n    action: stop\n",
+			wantErr: `step 1: unknown server "server9"`,
+		},
+		{
+			name:    "unknown action",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 30s\n    server: server1\n    action: reboot\n",
+			wantErr: `unknown action "reboot"`,
+		},
+		{
+			name:    "missing action",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 30s\n    server: server1\n",
+			wantErr: "missing action",
+		},
+		{
+			name:    "negative at",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: -1s\n    server: server1\n    action: stop\n",
+			wantErr: "at can't be negative",
+		},
+		{
+			name:    "negative latency",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 1s\n    server: server1\n    action: latency\n    latency: -5ms\n",
+			wantErr: "latency can't be negative",
+		},
+		{
+			name:    "negative for",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 1s\n    server: server1\n    action: stop\n    for: -5s\n",
+			wantErr: "for can't be negative",
+		},
+		{
+			name:    "ramp without over",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 1s\n    server: server1\n    action: ramp\n    from: 10ms\n    to: 50ms\n",
+			wantErr: "ramp must last more than 0s",
+		},
+		{
+			name:    "bad fault mode",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 1s\n    server: server1\n    action: fault\n    mode: slow\n    rate: 0.5\n",
+			wantErr: `unknown fault mode "slow"`,
+		},
+		{
+			name:    "bad model",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 1s\n    server: server1\n    action: model\n    model: gamma:1ms\n",
+			wantErr: "step 1",
+		},
+		{
+			name:    "second step is wrong",
+			file:    "scenario.yaml",
+			content: "steps:\n  - at: 1s\n    server: server1\n    action: stop\n  - at: 2s\n    server: server1\n    action: latency\n    latency: -1ms\n",
+			wantErr: "step 2",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := load(t, tc.file, tc.content)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("Expected an error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			expected := Step{At: 30 * time.Second, Server: "server2", Action: Latency, Latency: 200 * time.Millisecond}
+			if len(s.Steps) != 1 || s.Steps[0] != expected {
+				t.Errorf("Expected the step %+v, got %+v", expected, s.Steps)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ms := func(n int) chaos.Duration { return chaos.Duration(time.Duration(n) * time.Millisecond) }
+	sec := func(n int) chaos.Duration { return chaos.Duration(time.Duration(n) * time.Second) }
+
+	tests := []struct {
+		name     string
+		steps    []Step
+		expected []chaos.Event
+	}{
+		{
+			name:  "ramp ends at to",
+			steps: []Step{{At: 10 * time.Second, Server: "server1", Action: Ramp, From: 10 * time.Millisecond, To: 40 * time.Millisecond, Over: 3 * time.Second}},
+			expected: []chaos.Event{
+				{At: sec(10), Server: "server1", Action: chaos.SetLatency, Latency: ms(10)},
+				{At: sec(11), Server: "server1", Action: chaos.SetLatency, Latency: ms(20)},
+				{At: sec(12), Server: "server1", Action: chaos.SetLatency, Latency: ms(30)},
+				{At: sec(13), Server: "server1", Action: chaos.SetLatency, Latency: ms(40)},
+			},
+		},
+		{
+			name:  "ramp down every 2s",
+			steps: []Step{{Server: "server1", Action: Ramp, From: 50 * time.Millisecond, To: 10 * time.Millisecond, Over: 4 * time.Second, Every: 2 * time.Second}},
+			expected: []chaos.Event{
+				{At: 0, Server: "server1", Action: chaos.SetLatency, Latency: ms(50)},
+				{At: sec(2), Server: "server1", Action: chaos.SetLatency, Latency: ms(30)},
+				{At: sec(4), Server: "server1", Action: chaos.SetLatency, Latency: ms(10)},
+			},
+		},
+		{
+			name:  "stop for a while",
+			steps: []Step{{At: time.Second, Server: "server2", Action: Stop, For: 5 * time.Second}},
+			expected: []chaos.Event{
+				{At: sec(1), Server: "server2", Action: chaos.Stop},
+				{At: sec(6), Server: "server2", Action: chaos.Start},
+			},
+		},
+		{
+			name:     "stop for good",
+			steps:    []Step{{At: time.Second, Server: "server2", Action: Stop}},
+			expected: []chaos.Event{{At: sec(1), Server: "server2", Action: chaos.Stop}},
+		},
+		{
+			name:  "fault for a while",
+			steps: []Step{{At: time.Second, Server: "server3", Action: Fault, Mode: "spike", Rate: 0.5, Delay: 300 * time.Millisecond, For: 10 * time.Second}},
+			expected: []chaos.Event{
+				{At: sec(1), Server: "server3", Action: chaos.InjectFault, Fault: "spike", Rate: 0.5, Delay: ms(300)},
+				{At: sec(11), Server: "server3", Action: chaos.InjectFault, Fault: "spike"},
+			},
+		},
+		{
+			name: "steps out of order, same time keeps the written order",
+			steps: []Step{
+				{At: 5 * time.Second, Server: "server1", Action: Start},
+				{At: 2 * time.Second, Server: "server2", Action: Latency, Latency: 100 * time.Millisecond},
+				{At: 2 * time.Second, Server: "server1", Action: Model, Model: "constant:20ms"},
+				{At: 2 * time.Second, Server: "server3", Action: Stop},
+			},
+			expected: []chaos.Event{
+				{At: sec(2), Server: "server2", Action: chaos.SetLatency, Latency: ms(100)},
+				{At: sec(2), Server: "server1", Action: chaos.SetModel, Model: "constant:20ms"},
+				{At: sec(2), Server: "server3", Action: chaos.Stop},
+				{At: sec(5), Server: "server1", Action: chaos.Start},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Scenario{Steps: tc.steps}
+			if err := s.Validate(testServers); err != nil {
+				t.Fatalf("Expected a valid scenario, got %v", err)
+			}
+			events := s.Events()
+			if len(events) != len(tc.expected) {
+				t.Fatalf("Expected %d events, got %d: %+v", len(tc.expected), len(events), events)
+			}
+			for i := range events {
+				if events[i] != tc.expected[i] {
+					t.Errorf("Expected event %d to be %+v, got %+v", i, tc.expected[i], events[i])
+				}
+			}
+		})
+	}
+}
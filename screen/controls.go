@@ -0,0 +1,187 @@
+package screen
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"atomicgo.dev/keyboard"
+	"atomicgo.dev/keyboard/keys"
+	"github.com/containerd/console"
+	"github.com/pkg/errors"
+	"github.com/pterm/pterm"
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+	"github.com/tiagomelo/ewma-policy-poc/load"
+)
+
+const (
+	// latencyStep is how much a key press raises or lowers the latency of a server.
+	latencyStep = 10 * time.Millisecond
+	// rateStep is how much a key press raises or lowers the rates of the load profile,
+	// as a fraction of the rates it was started with.
+	rateStep = 0.1
+	// shareWindow is how many updates, a second apart, the share of each server is worked out over.
+	shareWindow = 10
+)
+
+const help = "↑/↓ select server   ←/→ latency -/+10ms   space stop/start   p pause chaos   +/- rate   q quit"
+
+// Controls are what the keys of the screen change during a run.
+type Controls struct {
+	// Servers are shown in a table, in order.
+	Servers []*dnsserver.Server
+	// Chaos pauses and resumes the chaos.
+	Chaos *chaos.Switch
+	// Profile is the load profile, scaled to change the rate of the requests.
+	Profile *load.Adjustable
+	// Apply applies a change to a server, as a chaos event.
+	Apply func(chaos.Event)
+	// Stop ends the run.
+	Stop func()
+	// Logger logs what goes wrong with the keys.
+	Logger *log.Logger
+}
+
+// Control shows the servers of c in a table, and, when the tester runs in a terminal,
+// changes the run as the keys are pressed.
+func (s *screen) Control(c *Controls) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.controls = c
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		c.Logger.Println("screen: stdin is not a terminal, keys are not listened to")
+		return nil
+	}
+	// the keyboard leaves the terminal in raw mode when it stops with an error, so it's
+	// saved here to restore it at the end.
+	terminal, err := console.ConsoleFromFile(os.Stdin)
+	if err != nil {
+		return errors.Wrap(err, "reading terminal")
+	}
+	s.console = terminal
+	go func() {
+		if err := keyboard.Listen(func(key keys.Key) (bool, error) {
+			return s.press(key), nil
+		}); err != nil {
+			c.Logger.Printf("screen: listening to keys: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// press changes the run as the key says, and tells whether to stop listening to keys.
+func (s *screen) press(key keys.Key) (stop bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	c := s.controls
+	server := c.Servers[s.selected]
+	switch {
+	case key.Code == keys.CtrlC || key.Code == keys.RuneKey && key.String() == "q":
+		c.Stop()
+		return true
+	case key.Code == keys.Up:
+		s.selected = (s.selected + len(c.Servers) - 1) % len(c.Servers)
+	case key.Code == keys.Down:
+		s.selected = (s.selected + 1) % len(c.Servers)
+	case key.Code == keys.Left || key.Code == keys.Right:
+		latency := server.GetLatency() + latencyStep
+		if key.Code == keys.Left {
+			latency = server.GetLatency() - latencyStep
+		}
+		if latency < 0 {
+			latency = 0
+		}
+		c.Apply(chaos.Event{Server: server.GetName(), Action: chaos.SetLatency, Latency: chaos.Duration(latency)})
+	case key.Code == keys.Space:
+		action := chaos.Stop
+		if !server.IsRunning() {
+			action = chaos.Start
+		}
+		c.Apply(chaos.Event{Server: server.GetName(), Action: action})
+	case key.Code == keys.RuneKey && key.String() == "p":
+		if c.Chaos.Paused() {
+			c.Chaos.Resume()
+		} else {
+			c.Chaos.Pause()
+		}
+	case key.Code == keys.RuneKey && (key.String() == "+" || key.String() == "-"):
+		factor := c.Profile.Factor() + rateStep
+		if key.String() == "-" {
+			factor = c.Profile.Factor() - rateStep
+		}
+		// never stop sending requests altogether.
+		if factor < rateStep {
+			factor = rateStep
+		}
+		if err := c.Profile.SetFactor(factor); err != nil {
+			c.Logger.Printf("screen: %v\n", err)
+			return false
+		}
+	default:
+		return false
+	}
+	if s.stats != nil {
+		if err := s.render(); err != nil {
+			c.Logger.Printf("screen: %v\n", err)
+		}
+	}
+	return false
+}
+
+// serversTable returns a table of the servers, with the selected one marked.
+func (s *screen) serversTable() (string, error) {
+	c := s.controls
+	var first, last map[string]int64
+	if len(s.answers) > 0 {
+		first, last = s.answers[0], s.answers[len(s.answers)-1]
+	}
+	var window int64
+	for upstream, n := range last {
+		window += n - first[upstream]
+	}
+
+	chaosState := "running"
+	if c.Chaos.Paused() {
+		chaosState = "paused"
+	}
+	data := [][]string{{"", "Server", "State", "Latency", "Share (10s)", "p95"}}
+	for i, server := range c.Servers {
+		selected := ""
+		if i == s.selected {
+			selected = ">"
+		}
+		state := "down"
+		if server.IsRunning() {
+			state = "up"
+		}
+		share := "-"
+		if window > 0 {
+			name := server.GetName()
+			share = fmt.Sprintf("%.1f%%", 100*float64(last[name]-first[name])/float64(window))
+		}
+		p95 := "-"
+		if d := s.stats.UpstreamDurationPercentile(server.GetName(), 95); d > 0 {
+			p95 = formatLatency(d)
+		}
+		data = append(data, []string{selected, server.GetName(), state, configuredLatency(server), share, p95})
+	}
+	table, err := pterm.DefaultTable.WithHasHeader().WithData(data).Srender()
+	if err != nil {
+		return "", errors.Wrap(err, "rendering servers table")
+	}
+	footer := fmt.Sprintf("chaos: %s   rate: x%.1f", chaosState, c.Profile.Factor())
+	return table + "\n" + footer, nil
+}
+
+// configuredLatency returns the median latency of the server, and its model unless it's constant.
+func configuredLatency(server *dnsserver.Server) string {
+	model := server.GetLatencyModel()
+	if _, ok := model.(dnsserver.Constant); ok {
+		return server.GetLatency().String()
+	}
+	name := strings.SplitN(model.String(), ":", 2)[0]
+	return fmt.Sprintf("%s (%s)", server.GetLatency(), name)
+}
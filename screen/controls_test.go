@@ -0,0 +1,140 @@
+package screen
+
+import (
+	"io"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"atomicgo.dev/keyboard/keys"
+	"github.com/tiagomelo/ewma-policy-poc/chaos"
+	"github.com/tiagomelo/ewma-policy-poc/dnsserver"
+	"github.com/tiagomelo/ewma-policy-poc/load"
+)
+
+func runeKey(r rune) keys.Key { return keys.Key{Code: keys.RuneKey, Runes: []rune{r}} }
+
+// newTestScreen returns a screen controlling two servers that aren't running, and the events
+// the keys applied. It has no stats, so it's never rendered.
+func newTestScreen(t *testing.T) (*screen, *[]chaos.Event, *bool) {
+	logger := log.New(io.Discard, "", 0)
+	servers := []*dnsserver.Server{
+		dnsserver.NewServerWithLogger("server1", 8051, 5, logger),
+		dnsserver.NewServerWithLogger("server2", 8052, 20, logger),
+	}
+	profile, err := load.NewProfile(load.ProfileConfig{Rate: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied []chaos.Event
+	stopped := false
+	s := &screen{controls: &Controls{
+		Servers: servers,
+		Chaos:   &chaos.Switch{},
+		Profile: load.NewAdjustable(profile),
+		Apply:   func(e chaos.Event) { applied = append(applied, e) },
+		Stop:    func() { stopped = true },
+		Logger:  logger,
+	}}
+	return s, &applied, &stopped
+}
+
+func TestPressChangesServers(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []keys.Key
+		expected []chaos.Event
+	}{
+		{
+			name:     "latency up",
+			keys:     []keys.Key{{Code: keys.Right}},
+			expected: []chaos.Event{{Server: "server1", Action: chaos.SetLatency, Latency: chaos.Duration(15 * time.Millisecond)}},
+		},
+		{
+			name:     "latency down never goes below 0",
+			keys:     []keys.Key{{Code: keys.Left}},
+			expected: []chaos.Event{{Server: "server1", Action: chaos.SetLatency, Latency: 0}},
+		},
+		{
+			name:     "select the next server",
+			keys:     []keys.Key{{Code: keys.Down}, {Code: keys.Left}},
+			expected: []chaos.Event{{Server: "server2", Action: chaos.SetLatency, Latency: chaos.Duration(10 * time.Millisecond)}},
+		},
+		{
+			name:     "selection wraps around",
+			keys:     []keys.Key{{Code: keys.Up}, {Code: keys.Right}, {Code: keys.Down}, {Code: keys.Right}},
+			expected: []chaos.Event{{Server: "server2", Action: chaos.SetLatency, Latency: chaos.Duration(30 * time.Millisecond)}, {Server: "server1", Action: chaos.SetLatency, Latency: chaos.Duration(15 * time.Millisecond)}},
+		},
+		{
+			name:     "space starts a stopped server",
+			keys:     []keys.Key{{Code: keys.Space}},
+			expected: []chaos.Event{{Server: "server1", Action: chaos.Start}},
+		},
+		{
+			name: "other keys do nothing",
+			keys: []keys.Key{runeKey('x'), {Code: keys.Enter}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, applied, stopped := newTestScreen(t)
+			for _, key := range tc.keys {
+				if s.press(key) {
+					t.Fatalf("Expected %s not to stop listening to keys", key)
+				}
+			}
+			if len(*applied) != len(tc.expected) {
+				t.Fatalf("Expected %d events, got %+v", len(tc.expected), *applied)
+			}
+			for i, e := range *applied {
+				if e != tc.expected[i] {
+					t.Errorf("Expected event %d to be %+v, got %+v", i, tc.expected[i], e)
+				}
+			}
+			if *stopped {
+				t.Error("Expected the run not to be stopped")
+			}
+		})
+	}
+}
+
+func TestPressPausesChaos(t *testing.T) {
+	s, _, _ := newTestScreen(t)
+	s.press(runeKey('p'))
+	if !s.controls.Chaos.Paused() {
+		t.Error("Expected p to pause the chaos")
+	}
+	s.press(runeKey('p'))
+	if s.controls.Chaos.Paused() {
+		t.Error("Expected p to resume the paused chaos")
+	}
+}
+
+func TestPressChangesRate(t *testing.T) {
+	s, _, _ := newTestScreen(t)
+	s.press(runeKey('+'))
+	s.press(runeKey('+'))
+	if x := s.controls.Profile.Factor(); math.Abs(x-1.2) > 1e-9 {
+		t.Errorf("Expected a rate factor of 1.2, got %v", x)
+	}
+	// the rate never drops to 0, however many times - is pressed.
+	for i := 0; i < 20; i++ {
+		s.press(runeKey('-'))
+	}
+	if x := s.controls.Profile.Factor(); math.Abs(x-rateStep) > 1e-9 {
+		t.Errorf("Expected a rate factor of %v, got %v", rateStep, x)
+	}
+}
+
+func TestPressQuits(t *testing.T) {
+	for _, key := range []keys.Key{runeKey('q'), {Code: keys.CtrlC}} {
+		s, _, stopped := newTestScreen(t)
+		if !s.press(key) {
+			t.Errorf("Expected %s to stop listening to keys", key)
+		}
+		if !*stopped {
+			t.Errorf("Expected %s to stop the run", key)
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	_ "embed"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/containerd/console"
 	"github.com/pkg/errors"
 	"github.com/pterm/pterm"
 	"github.com/tiagomelo/ewma-policy-poc/screen/stats"
@@ -21,6 +23,20 @@ var (
 type screen struct {
 	areaPrinter *pterm.AreaPrinter
 	layout      *pterm.CenterPrinter
+
+	// mux keeps the key presses from updating the screen at the same time as the stats.
+	mux sync.Mutex
+	// stats are the statistics of the last update, shown again on a key press.
+	stats *stats.Statistics
+	// controls, if set, are the servers shown and what the keys change.
+	controls *Controls
+	// console is the terminal before the keys were listened to, restored at the end.
+	console console.Console
+	// selected is the index of the server the keys change.
+	selected int
+	// answers are the answers of each upstream at the last updates, to work out their
+	// share over the last shareWindow updates.
+	answers []map[string]int64
 }
 
 // template is a helper function to define the
@@ -40,26 +56,61 @@ func New() (*screen, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "starting printer")
 	}
-	return &screen{area, new(pterm.CenterPrinter)}, nil
+	return &screen{areaPrinter: area, layout: new(pterm.CenterPrinter)}, nil
 }
 
 func (s *screen) UpdateContent(stats *stats.Statistics, finalUpdate bool) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stats = stats
+	s.answers = append(s.answers, stats.UpstreamAnswers())
+	if len(s.answers) > shareWindow+1 {
+		s.answers = s.answers[1:]
+	}
+	if err := s.render(); err != nil {
+		return err
+	}
+	if finalUpdate {
+		if s.console != nil {
+			if err := s.console.Reset(); err != nil {
+				return errors.Wrap(err, "restoring terminal")
+			}
+		}
+		if err := s.areaPrinter.Stop(); err != nil {
+			return errors.Wrap(err, "stopping printer")
+		}
+	}
+	return nil
+}
+
+// render shows the last stats.
+func (s *screen) render() error {
+	stats := s.stats
 	out := []string{
 		template("Total DNS requests", fmt.Sprintf("%d", stats.TotalDnsRequests())),
 		template("Total failed DNS requests", fmt.Sprintf("%d", stats.TotalFailedDnsRequests())),
-		template("DNS requests/second", fmt.Sprintf("%d", stats.RequestsPerSecond())),
+		template("Late DNS requests", fmt.Sprintf("%d", stats.TotalLateDnsRequests())),
+		template("Dropped DNS requests", fmt.Sprintf("%d", stats.TotalDroppedDnsRequests())),
+		template("Target DNS requests/second", fmt.Sprintf("%.1f", stats.TargetRequestsPerSecond())),
+		template("Achieved DNS requests/second", fmt.Sprintf("%.1f", stats.AchievedRequestsPerSecond())),
+		template("DNS query duration p50", formatLatency(stats.DnsQueryDurationPercentile(50))),
+		template("DNS query duration p95", formatLatency(stats.DnsQueryDurationPercentile(95))),
+		template("DNS query duration p99", formatLatency(stats.DnsQueryDurationPercentile(99))),
+		template("DNS query duration max", formatLatency(stats.MaxDnsQueryDuration())),
 		template("Available DNS servers", fmt.Sprintf("%d", stats.TotalAvailableServers())),
 		template("Unavailable DNS servers", fmt.Sprintf("%d", stats.TotalUnavailableServers())),
 		template("Elapsed Time", formatDuration(stats.ElapsedTime())),
 	}
 	banner := pterm.DefaultCenter.Sprint(string(banner))
 	content := s.layout.Sprint(strings.Join(out, "\n"))
-	s.areaPrinter.Update(banner + content)
-	if finalUpdate {
-		if err := s.areaPrinter.Stop(); err != nil {
-			return errors.Wrap(err, "stopping printer")
+	if s.controls != nil {
+		servers, err := s.serversTable()
+		if err != nil {
+			return err
 		}
+		content += "\n" + s.layout.Sprint(servers) + "\n" + s.layout.Sprint(help)
 	}
+	s.areaPrinter.Update(banner + content)
 	return nil
 }
 
@@ -73,3 +124,8 @@ func formatDuration(d time.Duration) string {
 	s := d / time.Second
 	return fmt.Sprintf("%02dh%02dm%02ds", h, m, s)
 }
+
+// formatLatency formats a query duration, rounded to a tenth of a millisecond.
+func formatLatency(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
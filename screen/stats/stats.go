@@ -1,8 +1,12 @@
 package stats
 
 import (
+	"math"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/tiagomelo/ewma-policy-poc/histogram"
 )
 
 // statistics contains information to be displayed at screen.
@@ -10,25 +14,48 @@ import (
 // since they'll be updated concurrently,
 // we want to make it thread-safe.
 type Statistics struct {
-	requestsPerSecond       int
-	totalDnsRequests        int64
-	totalFailedDnsRequests  int64
-	totalAvailableServers   int32
-	totalUnavailableServers int32
-	elapsedTime             time.Duration
+	targetRequestsPerSecond   uint64
+	achievedRequestsPerSecond uint64
+	totalDnsRequests          int64
+	totalFailedDnsRequests    int64
+	totalLateDnsRequests      int64
+	totalDroppedDnsRequests   int64
+	totalAvailableServers     int32
+	totalUnavailableServers   int32
+	elapsedTime               time.Duration
+	dnsQueryDurations         *histogram.Histogram
+
+	// answers and query durations of each upstream, by the name it answers with.
+	upstreamsMux      sync.Mutex
+	upstreamAnswers   map[string]int64
+	upstreamDurations map[string]*histogram.Histogram
 }
 
 // NewStatistics creates a new Statistics
 func New() *Statistics {
-	return &Statistics{}
+	return &Statistics{
+		dnsQueryDurations: histogram.New(),
+		upstreamAnswers:   make(map[string]int64),
+		upstreamDurations: make(map[string]*histogram.Histogram),
+	}
+}
+
+// rates are stored as the bits of a float64, so they can be updated atomically.
+
+func (s *Statistics) SetTargetRequestsPerSecond(rate float64) {
+	atomic.StoreUint64(&s.targetRequestsPerSecond, math.Float64bits(rate))
 }
 
-func (s *Statistics) SetRequestsPerSecond(requestsPerSecond int) {
-	s.requestsPerSecond = requestsPerSecond
+func (s *Statistics) TargetRequestsPerSecond() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.targetRequestsPerSecond))
 }
 
-func (s *Statistics) RequestsPerSecond() int {
-	return s.requestsPerSecond
+func (s *Statistics) SetAchievedRequestsPerSecond(rate float64) {
+	atomic.StoreUint64(&s.achievedRequestsPerSecond, math.Float64bits(rate))
+}
+
+func (s *Statistics) AchievedRequestsPerSecond() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.achievedRequestsPerSecond))
 }
 
 func (s *Statistics) IncrTotalDnsRequests() {
@@ -47,6 +74,22 @@ func (s *Statistics) TotalFailedDnsRequests() int64 {
 	return s.totalFailedDnsRequests
 }
 
+func (s *Statistics) IncrTotalLateDnsRequests() {
+	atomic.AddInt64(&s.totalLateDnsRequests, 1)
+}
+
+func (s *Statistics) TotalLateDnsRequests() int64 {
+	return atomic.LoadInt64(&s.totalLateDnsRequests)
+}
+
+func (s *Statistics) IncrTotalDroppedDnsRequests() {
+	atomic.AddInt64(&s.totalDroppedDnsRequests, 1)
+}
+
+func (s *Statistics) TotalDroppedDnsRequests() int64 {
+	return atomic.LoadInt64(&s.totalDroppedDnsRequests)
+}
+
 func (s *Statistics) SetTotalAvailableServers(total int) {
 	s.totalAvailableServers = int32(total)
 }
@@ -82,3 +125,51 @@ func (s *Statistics) UpdateElapsedTime(elapsedTime time.Duration) {
 func (s *Statistics) ElapsedTime() time.Duration {
 	return s.elapsedTime
 }
+
+func (s *Statistics) RecordDnsQueryDuration(d time.Duration) {
+	s.dnsQueryDurations.Record(d)
+}
+
+func (s *Statistics) DnsQueryDurationPercentile(p float64) time.Duration {
+	return s.dnsQueryDurations.Percentile(p)
+}
+
+func (s *Statistics) MaxDnsQueryDuration() time.Duration {
+	return s.dnsQueryDurations.Max()
+}
+
+// RecordUpstreamAnswer counts an answer of the given upstream, that took d.
+func (s *Statistics) RecordUpstreamAnswer(upstream string, d time.Duration) {
+	s.upstreamsMux.Lock()
+	h, ok := s.upstreamDurations[upstream]
+	if !ok {
+		h = histogram.New()
+		s.upstreamDurations[upstream] = h
+	}
+	s.upstreamAnswers[upstream]++
+	s.upstreamsMux.Unlock()
+	h.Record(d)
+}
+
+// UpstreamAnswers returns how many answers each upstream gave so far.
+func (s *Statistics) UpstreamAnswers() map[string]int64 {
+	s.upstreamsMux.Lock()
+	defer s.upstreamsMux.Unlock()
+	answers := make(map[string]int64, len(s.upstreamAnswers))
+	for upstream, n := range s.upstreamAnswers {
+		answers[upstream] = n
+	}
+	return answers
+}
+
+// UpstreamDurationPercentile returns the p percentile of the durations of the queries
+// the given upstream answered, 0 if it answered none.
+func (s *Statistics) UpstreamDurationPercentile(upstream string, p float64) time.Duration {
+	s.upstreamsMux.Lock()
+	h, ok := s.upstreamDurations[upstream]
+	s.upstreamsMux.Unlock()
+	if !ok {
+		return 0
+	}
+	return h.Percentile(p)
+}
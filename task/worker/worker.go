@@ -3,9 +3,11 @@ package worker
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/tiagomelo/ewma-policy-poc/digger"
+	"github.com/tiagomelo/ewma-policy-poc/report"
 	"github.com/tiagomelo/ewma-policy-poc/screen/stats"
 )
 
@@ -28,12 +30,25 @@ type Worker struct {
 	Digger *digger.Digger
 	Logger *log.Logger
 	Stats  *stats.Statistics
+	// Timeline, if set, counts the answers of each upstream over time.
+	Timeline *report.Timeline
 }
 
-func (w *Worker) Work(ctx context.Context) {
-	if err := w.Digger.Dig(w.Domain); err != nil {
-		w.Logger.Printf(`error when digging domain "%s": %v`, w.Domain, err)
+// Send implements load.Sender, it digs the domain.
+func (w *Worker) Send(ctx context.Context, intended time.Time) {
+	result, err := w.Digger.Query(w.Domain)
+	latency := time.Since(intended)
+	w.Stats.RecordDnsQueryDuration(latency)
+	digger.Observe(latency, err)
+	if err != nil {
+		w.Logger.Printf(`error when digging domain "%s" after %s: %v`, w.Domain, latency, err)
 		w.Stats.IncrTotalFailedDnsRequests()
 		failedDnsRequests.With(prometheus.Labels{"domain": w.Domain}).Inc()
+		return
 	}
+	w.Stats.RecordUpstreamAnswer(result.Upstream, latency)
+	if w.Timeline != nil {
+		w.Timeline.Add(time.Now(), result.Upstream)
+	}
+	w.Logger.Printf(`dug domain "%s", latency from intended send time: %s`, w.Domain, latency)
 }